@@ -1,13 +1,23 @@
 package main
 
 import (
+	"crypto/subtle"
+	"database/sql"
 	"encoding/base64"
 	"errors"
 	"fmt"
 	"net/http"
+	"net/mail"
+	"net/url"
 	"os"
 	"strconv"
+	"strings"
 	"thelsblog-server/internal/data"
+	"thelsblog-server/internal/diff"
+	"thelsblog-server/internal/jwt"
+	"thelsblog-server/internal/oidc"
+	"thelsblog-server/internal/totp"
+	"thelsblog-server/internal/webauthn"
 	"time"
 
 	"github.com/go-chi/chi/v5"
@@ -35,187 +45,2007 @@ func (app *application) Login(w http.ResponseWriter, r *http.Request) {
 	var payload jsonResponse
 
 	//We created a read and write json function in helpers.go so we dont ave to manually write the code everytime
+	// nothing from the body is logged, not even the error, it could have the password in it
 	err := app.readJSON(w, r, &creds)
 	if err != nil {
-		app.errorLog.Println(err)
 		payload.Error = true
 		payload.Message = "invalid json supplied or json missing entirely"
 		_ = app.writeJSON(w, http.StatusBadRequest, payload)
+		return
 	}
 
 	//AUTHENTICATE
-	app.infoLog.Println(creds.UserName, creds.Password)
+	// failed logins are counted per email address and per ip address, while either is blocked we don't
+	// even look at the password
+	accountKey, ipKey := data.AccountKey(creds.UserName), data.IPKey(app.clientIP(r))
+
+	if app.loginBlocked(w, accountKey, ipKey) {
+		return
+	}
 
 	// look up user by email
 	user, err := app.models.User.GetByEmail(creds.UserName)
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid username/password"))
+		app.loginFailed(w, accountKey, ipKey)
 		return
 	}
 
 	// validate the users  password
 	validPassword, err := user.PasswordMatches(creds.Password)
 	if err != nil || !validPassword {
-		app.errorJSON(w, errors.New("invalid username/password"))
+		app.loginFailed(w, accountKey, ipKey)
 		return
 	}
+
 	// make sure user is active
 	if user.Active == 0 {
 		app.errorJSON(w, errors.New("user is not active"))
 		return
 	}
 
-	// we have a valid user, so generate a token
-	token, err := app.models.Token.GenerateToken(user.ID, 24*time.Hour)
+	app.firstFactorSucceeded(w, r, user)
+}
+
+// firstFactorSucceeded is where a login goes after the password, or the identity provider, checked out.
+// With two factor authentication that is only the first step, the user gets a challenge token to send back
+// to LoginTwoFactor together with a code from their authenticator app. Without it they are logged in
+func (app *application) firstFactorSucceeded(w http.ResponseWriter, r *http.Request, user *data.User) {
+	twoFactor, err := app.models.User.GetTwoFactor(user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if twoFactor.Enabled {
+		challenge, err := app.models.OneTimeToken.New(user.ID, data.PurposeLoginChallenge, loginChallengeTTL)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		payload := jsonResponse{
+			Error:   false,
+			Message: "two factor code required",
+			Data:    envelope{"two_factor_required": true, "challenge_token": challenge.PlainText, "expiry": challenge.Expiry},
+		}
+
+		_ = app.writeJSON(w, http.StatusOK, payload)
+		return
+	}
+
+	app.loginSucceeded(w, r, user)
+}
+
+// loginBlocked answers with 429 and returns true when one of keys has failed to log in too often,
+// the caller stops there
+func (app *application) loginBlocked(w http.ResponseWriter, keys ...string) bool {
+	wait, err := app.models.LoginThrottle.Blocked(keys...)
+	if err != nil {
+		app.errorJSON(w, err)
+		return true
+	}
+
+	if wait > 0 {
+		app.tooManyLoginAttempts(w, wait)
+		return true
+	}
+
+	return false
+}
+
+// recordLoginFailure counts a failed login for the account and the ip address, accountKey is empty when we don't
+// know whose account it was. A wrong password, two factor code or passkey all count the same
+func (app *application) recordLoginFailure(accountKey, ipKey string) {
+	if accountKey != "" {
+		_, err := app.models.LoginThrottle.Failed(accountKey, app.config.loginPolicy.account)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+	}
+
+	_, err := app.models.LoginThrottle.Failed(ipKey, app.config.loginPolicy.ip)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// loginFailed counts a failed password login. It says the same whether the email address
+// or the password was wrong, so nobody finds out which email addresses have an account
+func (app *application) loginFailed(w http.ResponseWriter, accountKey, ipKey string) {
+	app.recordLoginFailure(accountKey, ipKey)
+	app.errorJSON(w, errors.New("invalid username/password"))
+}
+
+// loginSucceeded forgets the failed logins of the account and starts the session. Only a complete login gets here,
+// with two factor authentication that is after the code, so knowing the password doesn't reset the count
+func (app *application) loginSucceeded(w http.ResponseWriter, r *http.Request, user *data.User) {
+	err := app.models.LoginThrottle.Reset(data.AccountKey(user.Email))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.startSession(w, r, user)
+}
+
+// tooManyLoginAttempts tells the client to come back after wait
+func (app *application) tooManyLoginAttempts(w http.ResponseWriter, wait time.Duration) {
+	headers := make(http.Header)
+	headers.Set("Retry-After", strconv.Itoa(seconds(wait)))
+
+	payload := jsonResponse{
+		Error:   true,
+		Message: "too many failed login attempts, try again later",
+	}
+
+	_ = app.writeJSON(w, http.StatusTooManyRequests, payload, headers)
+}
+
+// how long the challenge token from Login can be exchanged for a session
+const loginChallengeTTL = 5 * time.Minute
+
+// LoginTwoFactor is the second step of logging in with two factor authentication, it exchanges the challenge token
+// from Login and a code from the authenticator app (or a recovery code) for a session. A challenge token only
+// works once, after a wrong code the user has to log in again
+func (app *application) LoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ChallengeToken string `json:"challenge_token"`
+		Code           string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	userID, err := app.models.OneTimeToken.Consume(requestPayload.ChallengeToken, data.PurposeLoginChallenge)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.models.User.GetByID(userID)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid username/password"))
+		return
+	}
+
+	if user.Active == 0 {
+		app.errorJSON(w, errors.New("user is not active"))
+		return
+	}
+
+	// wrong codes count as failed logins, or somebody with the password could keep guessing them
+	accountKey, ipKey := data.AccountKey(user.Email), data.IPKey(app.clientIP(r))
+	if app.loginBlocked(w, accountKey, ipKey) {
+		return
+	}
+
+	err = app.models.User.CheckTwoFactor(user.ID, requestPayload.Code)
+	if errors.Is(err, data.ErrInvalidTwoFactorCode) {
+		app.recordLoginFailure(accountKey, ipKey)
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	app.loginSucceeded(w, r, user)
+}
+
+// how long the browser has to answer a passkey ceremony
+const webAuthnChallengeTTL = 5 * time.Minute
+
+// PasskeyLoginStart is the first step of logging in with a passkey instead of a password, it returns the options
+// for navigator.credentials.get() with the passkeys of the user. The challenge in it is a one time token.
+// The answer looks the same for everybody, so nobody can find out who has an account or a passkey with it
+func (app *application) PasskeyLoginStart(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var challenge []byte
+	var allow [][]byte
+
+	user, err := app.models.User.GetByEmail(requestPayload.Email)
+	if err == nil && user.Active == 1 {
+		token, err := app.models.OneTimeToken.New(user.ID, data.PurposeWebAuthnLogin, webAuthnChallengeTTL)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		challenge = []byte(token.PlainText)
+
+		credentials, err := app.models.WebAuthnCredential.GetAllForUser(user.ID)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		for _, c := range credentials {
+			allow = append(allow, c.CredentialID)
+		}
+	} else {
+		// somebody we don't know gets options too, with a challenge that is not stored so finishing fails
+		plainText, err := data.RandomPlainText()
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		challenge = []byte(plainText)
+	}
+
+	// and a made up passkey, just like somebody without passkeys
+	if len(allow) == 0 {
+		allow = [][]byte{app.webauthn.FakeCredentialID(requestPayload.Email)}
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"options": app.webauthn.BeginLogin(challenge, allow)},
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// PasskeyLoginFinish checks the answer of the authenticator to PasskeyLoginStart and logs the user in.
+// A passkey is already two factors (the device and the pin or fingerprint), so there is no TOTP step after it.
+// Failed passkey logins count like wrong passwords
+func (app *application) PasskeyLoginFinish(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Credential webauthn.AssertionResponse `json:"credential"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	ipKey := data.IPKey(app.clientIP(r))
+	if app.loginBlocked(w, ipKey) {
+		return
+	}
+
+	user, challenge, err := app.passkeyLoginUser(requestPayload.Credential)
+	if err != nil {
+		app.errorLog.Println("passkey login:", err)
+		app.recordLoginFailure("", ipKey)
+		app.errorJSON(w, errors.New("passkey login failed"), http.StatusUnauthorized)
+		return
+	}
+
+	accountKey := data.AccountKey(user.Email)
+	if app.loginBlocked(w, accountKey) {
+		return
+	}
+
+	err = app.verifyPasskeyLogin(user, requestPayload.Credential, challenge)
+	if err != nil {
+		app.errorLog.Println("passkey login:", err)
+		app.recordLoginFailure(accountKey, ipKey)
+		app.errorJSON(w, errors.New("passkey login failed"), http.StatusUnauthorized)
+		return
+	}
+
+	app.loginSucceeded(w, r, user)
+}
+
+// passkeyLoginUser uses up the challenge of a passkey login response and returns the user it was made for,
+// with the challenge. Using it up first means a response can't be tried twice
+func (app *application) passkeyLoginUser(response webauthn.AssertionResponse) (*data.User, []byte, error) {
+	challenge, err := webauthn.Challenge(response.Response.ClientDataJSON)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userID, err := app.models.OneTimeToken.Consume(string(challenge), data.PurposeWebAuthnLogin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	user, err := app.models.User.GetByID(userID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if user.Active == 0 {
+		return nil, nil, errors.New("user is not active")
+	}
+
+	return user, challenge, nil
+}
+
+// verifyPasskeyLogin checks a passkey login response of user to challenge
+func (app *application) verifyPasskeyLogin(user *data.User, response webauthn.AssertionResponse, challenge []byte) error {
+	credential, err := app.models.WebAuthnCredential.GetByCredentialID(response.RawID)
+	if err != nil {
+		return err
+	}
+
+	if credential.UserID != user.ID {
+		return errors.New("passkey belongs to somebody else")
+	}
+
+	signCount, err := app.webauthn.FinishLogin(response, challenge, webauthn.Credential{
+		ID:        credential.CredentialID,
+		PublicKey: credential.PublicKey,
+		SignCount: uint32(credential.SignCount),
+	})
+	if err != nil {
+		return err
+	}
+
+	return app.models.WebAuthnCredential.Used(credential.ID, int64(signCount))
+}
+
+// the cookie that carries the state, nonce and PKCE verifier of a single sign on login from OIDCStart to OIDCCallback
+const oidcCookieName = "oidc_login"
+
+// how long somebody has to log in at the identity provider, and how long the front end has to swap the code it gets after
+const (
+	oidcLoginTTL       = 10 * time.Minute
+	oidcSessionCodeTTL = time.Minute
+)
+
+// OIDCStart sends the browser to the identity provider to log in. It is opened by the browser itself, not called with fetch
+func (app *application) OIDCStart(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.errorJSON(w, errors.New("single sign on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	var values []string
+	for i := 0; i < 3; i++ {
+		value, err := oidc.RandomString()
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		values = append(values, value)
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	authURL, err := app.oidc.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		app.errorLog.Println(err)
+		app.errorJSON(w, errors.New("the identity provider can't be reached"), http.StatusBadGateway)
+		return
+	}
+
+	// the random values are url safe base64, so they can't contain the dots between them
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookieName,
+		Value:    state + "." + nonce + "." + verifier,
+		Path:     "/auth/oidc",
+		MaxAge:   int(oidcLoginTTL / time.Second),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(app.config.oidc.redirectURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// OIDCCallback is where the identity provider sends the browser back to. The user is linked or created,
+// and the browser goes on to the front end with a code it swaps for a session with OIDCSession
+func (app *application) OIDCCallback(w http.ResponseWriter, r *http.Request) {
+	if app.oidc == nil {
+		app.errorJSON(w, errors.New("single sign on is not configured"), http.StatusNotFound)
+		return
+	}
+
+	// the cookie is only good for one try
+	http.SetCookie(w, &http.Cookie{Name: oidcCookieName, Path: "/auth/oidc", MaxAge: -1, HttpOnly: true})
+
+	code, err := app.oidcLogin(r)
+	if err != nil {
+		app.errorLog.Println("single sign on:", err)
+
+		message := "single sign on failed"
+		if errors.Is(err, data.ErrExternalEmailNotVerified) || errors.Is(err, data.ErrExternalLoginRefused) {
+			message = err.Error()
+		}
+
+		app.redirectToFrontend(w, r, "/login/sso", url.Values{"error": {message}})
+		return
+	}
+
+	app.redirectToFrontend(w, r, "/login/sso", url.Values{"code": {code}})
+}
+
+// oidcLogin checks what the identity provider sent back and returns a one time code for the session of the user
+func (app *application) oidcLogin(r *http.Request) (string, error) {
+	q := r.URL.Query()
+
+	if q.Get("error") != "" {
+		return "", fmt.Errorf("identity provider returned %s: %s", q.Get("error"), q.Get("error_description"))
+	}
+
+	cookie, err := r.Cookie(oidcCookieName)
+	if err != nil {
+		return "", errors.New("no login in progress")
+	}
+
+	parts := strings.Split(cookie.Value, ".")
+	if len(parts) != 3 || subtle.ConstantTimeCompare([]byte(parts[0]), []byte(q.Get("state"))) != 1 {
+		return "", errors.New("state does not match")
+	}
+
+	claims, err := app.oidc.Exchange(r.Context(), q.Get("code"), parts[1], parts[2])
+	if err != nil {
+		return "", err
+	}
+
+	user, err := app.models.UserIdentity.LinkOrProvision(data.ExternalUser{
+		Issuer:        app.oidc.Issuer(),
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified,
+		FirstName:     claims.GivenName,
+		LastName:      claims.FamilyName,
+	}, app.config.oidc.defaultRole)
+	if err != nil {
+		return "", err
+	}
+
+	if user.Active == 0 {
+		return "", data.ErrExternalLoginRefused
+	}
+
+	token, err := app.models.OneTimeToken.New(user.ID, data.PurposeExternalLogin, oidcSessionCodeTTL)
+	if err != nil {
+		return "", err
+	}
+
+	return token.PlainText, nil
+}
+
+// redirectToFrontend sends the browser to path on the front end, with params in the query string
+func (app *application) redirectToFrontend(w http.ResponseWriter, r *http.Request, path string, params url.Values) {
+	http.Redirect(w, r, strings.TrimSuffix(app.config.frontendURL, "/")+path+"?"+params.Encode(), http.StatusFound)
+}
+
+// OIDCSession swaps the code from OIDCCallback for a session, the same one Login gives. The identity provider
+// only stands in for the password: a user with two factor authentication gets a challenge for LoginTwoFactor,
+// or linking their account by email address would be a way around their second factor
+func (app *application) OIDCSession(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	userID, err := app.models.OneTimeToken.Consume(requestPayload.Code, data.PurposeExternalLogin)
+	if err != nil {
+		app.errorJSON(w, err, http.StatusUnauthorized)
+		return
+	}
+
+	user, err := app.models.User.GetByID(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if user.Active == 0 {
+		app.errorJSON(w, errors.New("user is not active"))
+		return
+	}
+
+	app.firstFactorSucceeded(w, r, user)
+}
+
+// startSession logs user in, it is the last step of every way of logging in
+func (app *application) startSession(w http.ResponseWriter, r *http.Request, user *data.User) {
+	// we have a valid user, so start a new session with an access and a refresh token.
+	// we keep where the session is used from, so the user can see where they are logged in
+	token, refreshToken, err := app.models.Token.NewSession(*user, app.storedAccessTTL(), app.config.refreshTokenTTL,
+		r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	token, err = app.accessToken(user, token, refreshToken.Family)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// send back a response
+	payload := jsonResponse{
+		Error:   false,
+		Message: "logged in",
+
+		// data envelope sent to front end to be able to store user amd token as cookie
+		Data: envelope{"token": token, "refresh_token": refreshToken, "user": user},
+	}
+
+	//We use our write json func we created at helper.go
+	err = app.writeJSON(w, http.StatusOK, payload)
+	if err != nil {
+		app.errorLog.Println(err)
+	}
+}
+
+// Refresh swaps a refresh token for a new access token and refresh token, the refresh token sent can't be used again
+func (app *application) Refresh(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, token, refreshToken, err := app.models.Token.Refresh(requestPayload.RefreshToken,
+		app.storedAccessTTL(), app.config.refreshTokenTTL, r.UserAgent(), app.clientIP(r))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	token, err = app.accessToken(user, token, refreshToken.Family)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "token refreshed",
+		Data:    envelope{"token": token, "refresh_token": refreshToken, "user": user},
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, errors.New("invalid json"))
+		return
+	}
+
+	// a JWT is not in the database, so we end the session it names
+	if app.jwt != nil && isJWT(requestPayload.Token) {
+		_, token, err := app.authenticateJWT(requestPayload.Token)
+		if err != nil {
+			app.errorJSON(w, err, http.StatusUnauthorized)
+			return
+		}
+
+		err = app.models.Token.DeleteFamilyForUser(token.Family, token.UserID)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			app.errorJSON(w, err)
+			return
+		}
+	} else {
+		err = app.models.Token.DeleteByToken(requestPayload.Token)
+		if err != nil {
+			app.errorJSON(w, errors.New("invalid json"))
+			return
+		}
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "logged out",
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+func (app *application) AllUsers(w http.ResponseWriter, r *http.Request) {
+	var users data.User
+	all, err := users.GetAll()
+	if err != nil {
+		app.errorLog.Println(err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"users": all},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// Edit user handler
+func (app *application) EditUser(w http.ResponseWriter, r *http.Request) {
+	var user data.User
+	err := app.readJSON(w, r, &user)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// make sure we only store roles we know about
+	if user.Role != "" && !data.ValidRole(user.Role) {
+		app.errorJSON(w, errors.New("invalid role"))
+		return
+	}
+
+	if user.ID == 0 {
+		//add user
+		// users made by an admin don't have to verify their email address
+		now := time.Now()
+		user.EmailVerifiedAt = &now
+		if _, err := app.models.User.Insert(user); err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	} else {
+		// editing user
+		u, err := app.models.User.GetByID(user.ID)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		u.Email = user.Email
+		u.FirstName = user.FirstName
+		u.LastName = user.LastName
+		u.Active = user.Active
+		if user.Role != "" {
+			u.Role = user.Role
+		}
+
+		if err := u.Update(); err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		// if password != string, update password
+		if user.Password != "" {
+			err := u.ResetPassword(user.Password)
+			if err != nil {
+				app.errorJSON(w, err)
+				return
+			}
+		}
+	}
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Changes saved",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// Get user handler
+func (app *application) GetUser(w http.ResponseWriter, r *http.Request) {
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, err := app.models.User.GetByID(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, user)
+}
+
+func (app *application) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	// the user goes to the trash, their blogs go to reassign_to when it is set
+	var requestPayload struct {
+		ID         int `json:"id"`
+		ReassignTo int `json:"reassign_to"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.User.DeleteByID(requestPayload.ID, requestPayload.ReassignTo)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "User moved to trash",
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// UnlockUser forgets the failed logins of a user, so they can log in again straight away
+func (app *application) UnlockUser(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ID int `json:"id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, err := app.models.User.GetByID(requestPayload.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.LoginThrottle.Reset(data.AccountKey(user.Email))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "user unlocked",
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// set a user as inactive when hey are log out
+func (app *application) LogUserOutAndSetInactive(w http.ResponseWriter, r *http.Request) {
+	//gets userID
+	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, err := app.models.User.GetByID(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// Sets user to inactive and saves to the database
+	user.Active = 0
+	err = user.Update()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	//delete token for user
+	err = app.models.Token.DeleteTokensForUser(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "user logged out and set to inactive",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+func (app *application) ValidateToken(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	valid := false
+	if app.jwt != nil && isJWT(requestPayload.Token) {
+		_, err = app.jwt.Verify(requestPayload.Token)
+		valid = err == nil
+	} else {
+		valid, _ = app.models.Token.ValidToken(requestPayload.Token)
+	}
+
+	payload := jsonResponse{
+		Error: false,
+		Data:  valid,
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// JWKS publishes the public keys our JWTs are signed with, so other services can verify them without asking us.
+// The list is empty when JWT mode is off or only HS256 keys are used
+func (app *application) JWKS(w http.ResponseWriter, r *http.Request) {
+	jwks := jwt.JWKS{Keys: []jwt.JWK{}}
+	if app.jwt != nil {
+		jwks = app.jwt.JWKS()
+	}
+
+	// other services cache this, the keys only change when we rotate them
+	headers := make(http.Header)
+	headers.Set("Cache-Control", "public, max-age=300")
+
+	_ = app.writeJSON(w, http.StatusOK, jwks, headers)
+}
+
+// Register creates an account for a reader. The account stays inactive until the link in the
+// verification email is followed, and is deleted when that does not happen in time
+func (app *application) Register(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email     string `json:"email"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Password  string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	address, err := mail.ParseAddress(requestPayload.Email)
+	if err != nil || address.Address != requestPayload.Email {
+		app.errorJSON(w, errors.New("invalid email address"))
+		return
+	}
+
+	if strings.TrimSpace(requestPayload.FirstName) == "" || strings.TrimSpace(requestPayload.LastName) == "" {
+		app.errorJSON(w, errors.New("first and last name are required"))
+		return
+	}
+
+	if len(requestPayload.Password) < minPasswordLength {
+		app.errorJSON(w, fmt.Errorf("password must be at least %d characters long", minPasswordLength))
+		return
+	}
+
+	// whether an address has an account is nobody's business, so the answer is the same either way.
+	// the owner of the address hears about it by email instead
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Follow the link in the email we sent you to activate your account",
+	}
+
+	// everybody who signs up is a reader, and can't log in before verifying their email address
+	user := data.User{
+		Email:     requestPayload.Email,
+		FirstName: requestPayload.FirstName,
+		LastName:  requestPayload.LastName,
+		Password:  requestPayload.Password,
+		Active:    0,
+		Role:      data.RoleReader,
+	}
+
+	existing, err := app.models.User.GetByEmail(requestPayload.Email)
+	if err == nil {
+		user.ID = existing.ID
+		app.registeredAgain(user, existing)
+		_ = app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// the address of somebody in the trash stays taken until they are purged
+	exists, err := app.models.User.EmailExists(requestPayload.Email)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	if exists {
+		_ = app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	}
+
+	// EmailExists can't see somebody registering with the same address at the same moment, the database can
+	user.ID, err = app.models.User.Insert(user)
+	if errors.Is(err, data.ErrDuplicateEmail) {
+		_ = app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.sendVerificationEmail(user)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// registeredAgain handles somebody registering with the address of existing. When that account was never verified
+// it gets the new name and password and a new verification link, so nobody is stuck until DeleteUnverified
+// cleans it up. Otherwise the owner gets an email saying they already have an account.
+// Errors are only logged, the answer to Register has to look the same whatever happens here
+func (app *application) registeredAgain(user data.User, existing *data.User) {
+	if existing.EmailVerifiedAt == nil {
+		err := app.models.User.RestartRegistration(user)
+		if err == nil {
+			err = app.sendVerificationEmail(user)
+		}
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+		return
+	}
+
+	app.background(func() {
+		emailData := map[string]interface{}{
+			"Name":      existing.FirstName,
+			"LoginLink": fmt.Sprintf("%s/login", app.config.frontendURL),
+			"ResetLink": fmt.Sprintf("%s/forgot-password", app.config.frontendURL),
+		}
+
+		err := app.mailer.Send(existing.Email, "account_exists.tmpl", emailData)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+	})
+}
+
+// sendVerificationEmail sends user a new link to verify their email address, older links stop working
+func (app *application) sendVerificationEmail(user data.User) error {
+	token, err := app.models.OneTimeToken.New(user.ID, data.PurposeEmailVerification, app.config.verificationTTL)
+	if err != nil {
+		return err
+	}
+
+	app.background(func() {
+		emailData := map[string]interface{}{
+			"Name":      user.FirstName,
+			"Link":      fmt.Sprintf("%s/verify-email?token=%s", app.config.frontendURL, url.QueryEscape(token.PlainText)),
+			"ExpiresIn": humanDuration(app.config.verificationTTL),
+		}
+
+		err := app.mailer.Send(user.Email, "email_verification.tmpl", emailData)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+	})
+
+	return nil
+}
+
+// VerifyEmail activates an account with the token from the link in the verification email
+func (app *application) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token string `json:"token"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	userID, err := app.models.OneTimeToken.Consume(requestPayload.Token, data.PurposeEmailVerification)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.User.VerifyEmail(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Email address verified, you can log in now",
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// session is how a token family is shown in the list of sessions of a user
+type session struct {
+	ID         string     `json:"id"`
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	Expiry     time.Time  `json:"expiry"`
+	Current    bool       `json:"current"`
+}
+
+// Sessions lists the sessions of the logged in user, the one making the request is marked as current
+func (app *application) Sessions(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	current := app.contextGetToken(r)
+
+	tokens, err := app.models.Token.GetAllForUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	sessions := []session{}
+	for _, t := range tokens {
+		sessions = append(sessions, session{
+			ID:         t.Family,
+			UserAgent:  t.UserAgent,
+			IP:         t.IP,
+			CreatedAt:  t.CreatedAt,
+			LastUsedAt: t.LastUsedAt,
+			Expiry:     t.Expiry,
+			Current:    current != nil && t.Family == current.Family,
+		})
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"sessions": sessions},
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// RevokeSession logs the logged in user out of one of their sessions
+func (app *application) RevokeSession(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ID string `json:"id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.Token.DeleteFamilyForUser(requestPayload.ID, user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Session revoked",
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// RevokeOtherSessions logs the logged in user out everywhere except in the session making the request
+func (app *application) RevokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+	current := app.contextGetToken(r)
+
+	n, err := app.models.Token.DeleteOthersForUser(user.ID, current.Family)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: fmt.Sprintf("%d other session(s) revoked", n),
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// TwoFactorStatus says whether the logged in user has two factor authentication, and how many recovery codes are left
+func (app *application) TwoFactorStatus(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	status, err := app.models.User.GetTwoFactor(user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"two_factor": status},
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// EnrollTwoFactor starts setting up two factor authentication for the logged in user. The otpauth uri goes in a
+// QR code for the authenticator app, the secret is for typing it in by hand. It is on after ConfirmTwoFactor
+func (app *application) EnrollTwoFactor(w http.ResponseWriter, r *http.Request) {
+	// in JWT mode the user in the context only has what is in the token, so we get the email address here
+	user, err := app.models.User.GetByID(app.contextGetUser(r).ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	secret, err := app.models.User.StartTOTP(user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "scan the QR code with your authenticator app and confirm with a code",
+		Data:    envelope{"secret": secret, "uri": totp.URI(app.config.totpIssuer, user.Email, secret)},
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// ConfirmTwoFactor turns two factor authentication on with a code from the authenticator app,
+// and returns the recovery codes. They are not shown again
+func (app *application) ConfirmTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	codes, err := app.models.User.ConfirmTOTP(user.ID, requestPayload.Code)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Two factor authentication enabled, keep the recovery codes somewhere safe",
+		Data:    envelope{"recovery_codes": codes},
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DisableTwoFactor turns two factor authentication off, which takes the password and a current code
+func (app *application) DisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Password string `json:"password"`
+		Code     string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, err := app.models.User.GetByID(app.contextGetUser(r).ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	validPassword, err := user.PasswordMatches(requestPayload.Password)
+	if err != nil || !validPassword {
+		app.errorJSON(w, errors.New("invalid password"), http.StatusUnauthorized)
+		return
+	}
+
+	err = app.models.User.CheckTwoFactor(user.ID, requestPayload.Code)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = app.models.User.DisableTOTP(user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Two factor authentication disabled",
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// RegenerateRecoveryCodes gives the logged in user new recovery codes for a current code, the old ones stop working
+func (app *application) RegenerateRecoveryCodes(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Code string `json:"code"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.User.CheckTwoFactor(user.ID, requestPayload.Code)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	codes, err := app.models.User.RegenerateRecoveryCodes(user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "New recovery codes generated, the old ones no longer work",
+		Data:    envelope{"recovery_codes": codes},
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// Passkeys lists the passkeys of the logged in user
+func (app *application) Passkeys(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	credentials, err := app.models.WebAuthnCredential.GetAllForUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if credentials == nil {
+		credentials = []*data.WebAuthnCredential{}
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"passkeys": credentials},
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// PasskeyRegisterStart returns the options for navigator.credentials.create() to add a passkey for the logged in user
+func (app *application) PasskeyRegisterStart(w http.ResponseWriter, r *http.Request) {
+	user, err := app.models.User.GetByID(app.contextGetUser(r).ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	token, err := app.models.OneTimeToken.New(user.ID, data.PurposeWebAuthnRegistration, webAuthnChallengeTTL)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	credentials, err := app.models.WebAuthnCredential.GetAllForUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	var exclude [][]byte
+	for _, c := range credentials {
+		exclude = append(exclude, c.CredentialID)
+	}
+
+	options := app.webauthn.BeginRegistration(webauthn.User{
+		ID:          []byte(strconv.Itoa(user.ID)),
+		Name:        user.Email,
+		DisplayName: strings.TrimSpace(user.FirstName + " " + user.LastName),
+	}, []byte(token.PlainText), exclude)
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"options": options},
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// PasskeyRegisterFinish checks the answer of the authenticator to PasskeyRegisterStart and saves the new passkey
+func (app *application) PasskeyRegisterFinish(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Name       string                        `json:"name"`
+		Credential webauthn.RegistrationResponse `json:"credential"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	challenge, err := webauthn.Challenge(requestPayload.Credential.Response.ClientDataJSON)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	userID, err := app.models.OneTimeToken.Consume(string(challenge), data.PurposeWebAuthnRegistration)
+	if err != nil || userID != user.ID {
+		app.errorJSON(w, data.ErrInvalidOneTimeToken)
+		return
+	}
+
+	credential, err := app.webauthn.FinishRegistration(requestPayload.Credential, challenge)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	name := strings.TrimSpace(requestPayload.Name)
+	if name == "" {
+		name = "Passkey"
+	}
+
+	id, err := app.models.WebAuthnCredential.Insert(data.WebAuthnCredential{
+		UserID:       user.ID,
+		CredentialID: credential.ID,
+		PublicKey:    credential.PublicKey,
+		SignCount:    int64(credential.SignCount),
+		Name:         name,
+	})
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Passkey added",
+		Data:    envelope{"id": id},
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// DeletePasskey removes a passkey of the logged in user
+func (app *application) DeletePasskey(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ID int `json:"id"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	err = app.models.WebAuthnCredential.DeleteForUser(requestPayload.ID, user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Passkey removed",
+	}
+
+	_ = app.writeJSON(w, http.StatusOK, payload)
+}
+
+// how long a password reset link works
+const passwordResetTTL = time.Hour
+
+// minPasswordLength is the shortest password a user can pick for themselves
+const minPasswordLength = 8
+
+// ForgotPassword emails a password reset link to the user with the given email address.
+// The response is the same whether or not we know the address, so it can't be used to find out who has an account
+func (app *application) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Email string `json:"email"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "If that email address has an account, a password reset link is on its way",
+	}
+
+	user, err := app.models.User.GetByEmail(requestPayload.Email)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			app.errorLog.Println(err)
+		}
+		_ = app.writeJSON(w, http.StatusAccepted, payload)
+		return
+	}
+
+	token, err := app.models.OneTimeToken.New(user.ID, data.PurposePasswordReset, passwordResetTTL)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// sending can take a while, the client does not have to wait for it
+	app.background(func() {
+		emailData := map[string]interface{}{
+			"Name":      user.FirstName,
+			"Link":      fmt.Sprintf("%s/reset-password?token=%s", app.config.frontendURL, url.QueryEscape(token.PlainText)),
+			"ExpiresIn": humanDuration(passwordResetTTL),
+		}
+
+		err := app.mailer.Send(user.Email, "password_reset.tmpl", emailData)
+		if err != nil {
+			app.errorLog.Println(err)
+		}
+	})
+
+	_ = app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// ResetPassword sets a new password with the token from a password reset link.
+// The token only works once, every session of the user is logged out and their failed logins are forgotten
+func (app *application) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// check the password before the token is used up
+	if len(requestPayload.Password) < minPasswordLength {
+		app.errorJSON(w, fmt.Errorf("password must be at least %d characters long", minPasswordLength))
+		return
+	}
+
+	userID, err := app.models.OneTimeToken.Consume(requestPayload.Token, data.PurposePasswordReset)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user, err := app.models.User.GetByID(userID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	err = user.ResetPassword(requestPayload.Password)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// the failed logins were with the old password, the new one works straight away
+	err = app.models.LoginThrottle.Reset(data.AccountKey(user.Email))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// whoever had the old password may still be logged in
+	err = app.models.Token.DeleteTokensForUser(user.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Password changed, you can log in with your new password",
+	}
+
+	_ = app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// Display a page of our published blogs
+// the listing can be paginated, sorted and filtered with the query string, see readBlogFilters.
+// When there is a cursor parameter, even an empty one for the first page, the feed is paged with
+// cursors instead of page numbers and the response has next_cursor and prev_cursor instead of metadata
+func (app *application) AllBlogs(w http.ResponseWriter, r *http.Request) {
+	filters, err := app.readBlogFilters(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	filters.PublishedOnly = true
+
+	if r.URL.Query().Has("cursor") {
+		app.blogFeed(w, r, filters)
+		return
+	}
+
+	blogs, metadata, err := app.models.Blog.GetAllPaginated(filters)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"blogs": blogs, "metadata": metadata},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// Search the published blogs, q is the search query and the same filters as the blog listing can be used
+func (app *application) SearchBlogs(w http.ResponseWriter, r *http.Request) {
+	search := strings.TrimSpace(r.URL.Query().Get("q"))
+	if search == "" {
+		app.errorJSON(w, errors.New("q must be provided"))
+		return
+	}
+
+	filters, err := app.readBlogFilters(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+	filters.PublishedOnly = true
+
+	results, metadata, err := app.models.Blog.Search(search, filters)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"blogs": results, "metadata": metadata},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// blogFeed writes the page of the blog feed after the cursor in the query string
+func (app *application) blogFeed(w http.ResponseWriter, r *http.Request, filters data.BlogFilters) {
+	var cursor *data.Cursor
+	if s := r.URL.Query().Get("cursor"); s != "" {
+		c, err := data.DecodeCursor(s)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+		cursor = c
+	}
+
+	blogs, page, err := app.models.Blog.GetFeed(filters, cursor, filters.PageSize)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"blogs": blogs, "next_cursor": page.NextCursor, "prev_cursor": page.PrevCursor},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// Display a page of the published blogs in a category
+func (app *application) CategoryBlogs(w http.ResponseWriter, r *http.Request) {
+	slug := chi.URLParam(r, "slug")
+
+	p, err := app.readPagination(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// make sure the category exists so we can tell an unknown category from an empty one
+	_, err = app.models.Category.GetBySlug(slug)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	blogs, metadata, err := app.models.Blog.GetPublishedByCategory(slug, p)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"blogs": blogs, "metadata": metadata},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// Display a page of the published blogs of an author
+func (app *application) AuthorBlogs(w http.ResponseWriter, r *http.Request) {
+	authorID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	p, err := app.readPagination(r)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	// make sure the author exists so we can tell an unknown author from one without blogs
+	_, err = app.models.User.GetByID(authorID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	blogs, metadata, err := app.models.Blog.GetPublishedByAuthor(authorID, p)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"blogs": blogs, "metadata": metadata},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// Get only one blog based on their slug
+func (app *application) OneBlog(w http.ResponseWriter, r *http.Request) {
+	slug := chi.URLParam(r, "slug")
+
+	blog, err := app.models.Blog.GetPublishedBySlug(slug)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error: false,
+		Data:  blog,
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// create or update a blog
+// the author of a new blog is always the logged in user, never something sent in the payload
+func (app *application) EditBlog(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ID           int    `json:"id"`
+		Title        string `json:"title"`
+		Description  string `json:"description"`
+		Content      string `json:"content"`
+		BannerBase64 string `json:"banner"`
+		CategoryIDs  []int  `json:"category_ids"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	blog := data.Blog{
+		ID:          requestPayload.ID,
+		Title:       requestPayload.Title,
+		CreatedByID: user.ID,
+		Description: requestPayload.Description,
+		Content:     requestPayload.Content,
+		Slug:        slugify.Slugify(requestPayload.Title),
+		CategoryIDs: requestPayload.CategoryIDs,
+		UpdatedByID: user.ID,
+	}
+
+	if blog.ID == 0 {
+		// adding a blog
+		_, err := app.models.Blog.Create(blog)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	} else if user.HasRole(data.RoleAdmin, data.RoleEditor) {
+		// editors and admins can update any blog
+		err := blog.Update()
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	} else {
+		// everybody else can only update their own
+		err := blog.UpdateByOwner(user.ID)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+	}
+
+	// image decoding to check if we have a banner
+	// we only write it once the blog is saved so nobody can overwrite the banner of a blog they don't own
+	if len(requestPayload.BannerBase64) > 0 {
+		// we have a banner
+		decoded, err := base64.StdEncoding.DecodeString(requestPayload.BannerBase64)
+		if err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+		// write image to /static/banners
+		if err := os.WriteFile(fmt.Sprintf("%s/banners/%s.jpg", staticPath, blog.Slug), decoded, 0666); err != nil {
+			app.errorJSON(w, err)
+			return
+		}
+
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Changes saved",
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// Display a list of all blogs for the admin, drafts included
+// authors only get their own blogs, the drafts of other authors are none of their business
+func (app *application) AdminAllBlogs(w http.ResponseWriter, r *http.Request) {
+	var blogs []*data.Blog
+	var err error
+
+	user := app.contextGetUser(r)
+	if user.HasRole(data.RoleAdmin, data.RoleEditor) {
+		blogs, err = app.models.Blog.GetAll()
+	} else {
+		blogs, err = app.models.Blog.GetAllForOwner(user.ID)
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"blogs": blogs},
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// move a blog through the publication workflow
+// authors can only move their own blogs between draft and in review, anything to do with
+// published or scheduled blogs is for editors and admins
+func (app *application) SetBlogStatus(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ID        int        `json:"id"`
+		Status    string     `json:"status"`
+		PublishAt *time.Time `json:"publish_at"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	if !data.ValidBlogStatus(requestPayload.Status) {
+		app.errorJSON(w, errors.New("invalid status"))
+		return
+	}
+
+	blog, err := app.blogForUser(r, requestPayload.ID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	if !user.HasRole(data.RoleAdmin, data.RoleEditor) && !blog.AuthorCanTransitionTo(requestPayload.Status) {
+		app.errorJSON(w, errors.New("authors can only move their blogs between draft and in review"), http.StatusForbidden)
+		return
+	}
+
+	err = blog.SetStatus(requestPayload.Status, requestPayload.PublishAt)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Status changed",
+		Data:    blog,
+	}
+
+	app.writeJSON(w, http.StatusAccepted, payload)
+}
+
+// get blog by id, authors can only get their own
+func (app *application) BlogByID(w http.ResponseWriter, r *http.Request) {
+	blogID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	// save it to database
-	err = app.models.Token.Insert(*token, *user)
+	blog, err := app.blogForUser(r, blogID)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	// send back a response
-	payload = jsonResponse{
-		Error:   false,
-		Message: "logged in",
-
-		// data envelope sent to front end to be able to store user amd token as cookie
-		Data: envelope{"token": token, "user": user},
-	}
-
-	//We use our write json func we created at helper.go
-	err = app.writeJSON(w, http.StatusOK, payload)
-	if err != nil {
-		app.errorLog.Println(err)
+	payload := jsonResponse{
+		Error: false,
+		Data:  blog,
 	}
+	app.writeJSON(w, http.StatusOK, payload)
 
 }
 
-func (app *application) Logout(w http.ResponseWriter, r *http.Request) {
+// delete blog
+func (app *application) DeleteBlog(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
-		Token string `json:"token"`
+		ID int `json:"id"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid json"))
+		app.errorJSON(w, err)
 		return
 	}
 
-	err = app.models.Token.DeleteByToken(requestPayload.Token)
+	user := app.contextGetUser(r)
+
+	if user.HasRole(data.RoleAdmin, data.RoleEditor) {
+		err = app.models.Blog.DeleteByID(requestPayload.ID)
+	} else {
+		err = app.models.Blog.DeleteByIDForOwner(requestPayload.ID, user.ID)
+	}
 	if err != nil {
-		app.errorJSON(w, errors.New("invalid json"))
+		app.errorJSON(w, err)
 		return
 	}
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: "logged out",
+		Message: "Blog moved to trash",
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, payload)
+	app.writeJSON(w, http.StatusOK, payload)
 }
 
-func (app *application) AllUsers(w http.ResponseWriter, r *http.Request) {
-	var users data.User
-	all, err := users.GetAll()
+// blogForUser gets a blog by id for the logged in user,
+// editors and admins get every blog while everybody else gets data.ErrNotBlogOwner for blogs that are not theirs
+func (app *application) blogForUser(r *http.Request, id int) (*data.Blog, error) {
+	blog, err := app.models.Blog.GetOneById(id)
 	if err != nil {
-		app.errorLog.Println(err)
-		return
+		return nil, err
 	}
 
-	payload := jsonResponse{
-		Error:   false,
-		Message: "success",
-		Data:    envelope{"users": all},
+	user := app.contextGetUser(r)
+	if !user.HasRole(data.RoleAdmin, data.RoleEditor) && blog.CreatedByID != user.ID {
+		return nil, data.ErrNotBlogOwner
 	}
 
-	app.writeJSON(w, http.StatusOK, payload)
+	return blog, nil
 }
 
-// Edit user handler
-func (app *application) EditUser(w http.ResponseWriter, r *http.Request) {
-	var user data.User
-	err := app.readJSON(w, r, &user)
+// list the revisions of a blog
+func (app *application) BlogRevisions(w http.ResponseWriter, r *http.Request) {
+	blogID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	if user.ID == 0 {
-		//add user
-		if _, err := app.models.User.Insert(user); err != nil {
-			app.errorJSON(w, err)
-			return
-		}
-	} else {
-		// editing user
-		u, err := app.models.User.GetByID(user.ID)
-		if err != nil {
-			app.errorJSON(w, err)
-			return
-		}
-		u.Email = user.Email
-		u.FirstName = user.FirstName
-		u.LastName = user.LastName
-		u.Active = user.Active
-
-		if err := u.Update(); err != nil {
-			app.errorJSON(w, err)
-			return
-		}
+	_, err = app.blogForUser(r, blogID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
 
-		// if password != string, update password
-		if user.Password != "" {
-			err := u.ResetPassword(user.Password)
-			if err != nil {
-				app.errorJSON(w, err)
-				return
-			}
-		}
+	revisions, err := app.models.BlogRevision.GetAllForBlog(blogID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
 	}
+
 	payload := jsonResponse{
 		Error:   false,
-		Message: "Changes saved",
+		Message: "success",
+		Data:    envelope{"revisions": revisions},
 	}
 
-	_ = app.writeJSON(w, http.StatusAccepted, payload)
+	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// Get user handler
-func (app *application) GetUser(w http.ResponseWriter, r *http.Request) {
-	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+// get a unified diff between two revisions of a blog
+func (app *application) BlogRevisionDiff(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		From int `json:"from"`
+		To   int `json:"to"`
+	}
+
+	blogID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	user, err := app.models.User.GetByID(userID)
+	err = app.readJSON(w, r, &requestPayload)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, user)
-}
+	_, err = app.blogForUser(r, blogID)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
 
-func (app *application) DeleteUser(w http.ResponseWriter, r *http.Request) {
-	var requestPayload struct {
-		ID int `json:"id"`
+	from, err := app.revisionForBlog(blogID, requestPayload.From)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
 	}
 
-	err := app.readJSON(w, r, &requestPayload)
+	to, err := app.revisionForBlog(blogID, requestPayload.To)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	err = app.models.User.DeleteByID(requestPayload.ID)
+	unified, err := diff.Unified(from.Label(), to.Label(), from.Document(), to.Document(), 3)
+	if errors.Is(err, diff.ErrTooLarge) {
+		app.errorJSON(w, err, http.StatusUnprocessableEntity)
+		return
+	}
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -223,117 +2053,108 @@ func (app *application) DeleteUser(w http.ResponseWriter, r *http.Request) {
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: "User deleted",
+		Message: "success",
+		Data: envelope{
+			"from": from.Revision,
+			"to":   to.Revision,
+			"diff": unified,
+		},
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, payload)
+	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// set a user as inactive when hey are log out
-func (app *application) LogUserOutAndSetInactive(w http.ResponseWriter, r *http.Request) {
-	//gets userID
-	userID, err := strconv.Atoi(chi.URLParam(r, "id"))
+// restore an old revision of a blog, it becomes the current version of the blog as a new revision
+func (app *application) RestoreBlogRevision(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		RevisionID int `json:"revision_id"`
+	}
+
+	blogID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	user, err := app.models.User.GetByID(userID)
+	err = app.readJSON(w, r, &requestPayload)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	// Sets user to inactive and saves to the database
-	user.Active = 0
-	err = user.Update()
+	blog, err := app.blogForUser(r, blogID)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	//delete token for user
-	err = app.models.Token.DeleteTokensForUser(userID)
+	revision, err := app.revisionForBlog(blogID, requestPayload.RevisionID)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	payload := jsonResponse{
-		Error:   false,
-		Message: "user logged out and set to inactive",
-	}
+	user := app.contextGetUser(r)
 
-	_ = app.writeJSON(w, http.StatusAccepted, payload)
-}
+	blog.Title = revision.Title
+	blog.Description = revision.Description
+	blog.Content = revision.Content
+	blog.UpdatedByID = user.ID
 
-func (app *application) ValidateToken(w http.ResponseWriter, r *http.Request) {
-	var requestPayload struct {
-		Token string `json:"token"`
+	if user.HasRole(data.RoleAdmin, data.RoleEditor) {
+		err = blog.Update()
+	} else {
+		err = blog.UpdateByOwner(user.ID)
 	}
-
-	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	valid := false
-	valid, _ = app.models.Token.ValidToken(requestPayload.Token)
-
 	payload := jsonResponse{
-		Error: false,
-		Data:  valid,
+		Error:   false,
+		Message: fmt.Sprintf("Revision %d restored", revision.Revision),
 	}
 
-	_ = app.writeJSON(w, http.StatusOK, payload)
+	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-// Display a list of all our blogs
-func (app *application) AllBlogs(w http.ResponseWriter, r *http.Request) {
-	blogs, err := app.models.Blog.GetAll()
+// revisionForBlog gets a revision by id and makes sure it belongs to blogID
+func (app *application) revisionForBlog(blogID, revisionID int) (*data.BlogRevision, error) {
+	revision, err := app.models.BlogRevision.GetByID(revisionID)
 	if err != nil {
-		app.errorJSON(w, err)
-		return
+		return nil, err
 	}
 
-	payload := jsonResponse{
-		Error:   false,
-		Message: "success",
-		Data:    envelope{"blogs": blogs},
+	if revision.BlogID != blogID {
+		return nil, sql.ErrNoRows
 	}
 
-	app.writeJSON(w, http.StatusOK, payload)
+	return revision, nil
 }
 
-// Get only one blog based on their slug
-func (app *application) OneBlog(w http.ResponseWriter, r *http.Request) {
-	slug := chi.URLParam(r, "slug")
-
-	blog, err := app.models.Blog.GetOneBySlug(slug)
+// list all categories with the number of published blogs in each of them
+func (app *application) AllCategories(w http.ResponseWriter, r *http.Request) {
+	categories, err := app.models.Category.GetAll()
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
 	payload := jsonResponse{
-		Error: false,
-		Data:  blog,
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"categories": categories},
 	}
 
 	app.writeJSON(w, http.StatusOK, payload)
 }
 
-// get all creators
-func (app *application) EditBlog(w http.ResponseWriter, r *http.Request) {
+// create or rename a category
+func (app *application) EditCategory(w http.ResponseWriter, r *http.Request) {
 	var requestPayload struct {
 		ID           int    `json:"id"`
-		Title        string `json:"title"`
-		CreatedByID  int    `json:"createdby_id"`
-		Description  string `json:"description"`
-		Content      string `json:"content"`
-		BannerBase64 string `json:"banner"`
-		CategoryIDs  []int  `json:"category_ids"`
+		CategoryName string `json:"category_name"`
 	}
 
 	err := app.readJSON(w, r, &requestPayload)
@@ -342,43 +2163,28 @@ func (app *application) EditBlog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	blog := data.Blog{
-		ID:          requestPayload.ID,
-		Title:       requestPayload.Title,
-		CreatedByID: requestPayload.CreatedByID,
-		Description: requestPayload.Description,
-		Content:     requestPayload.Content,
-		Slug:        slugify.Slugify(requestPayload.Title),
-		CategoryIDs: requestPayload.CategoryIDs,
+	if strings.TrimSpace(requestPayload.CategoryName) == "" {
+		app.errorJSON(w, errors.New("category name is required"))
+		return
 	}
 
-	// image decoding to check if we have a banner
-	if len(requestPayload.BannerBase64) > 0 {
-		// we have a banner
-		decoded, err := base64.StdEncoding.DecodeString(requestPayload.BannerBase64)
+	if requestPayload.ID == 0 {
+		// adding a category
+		_, err := app.models.Category.Insert(data.Category{CategoryName: requestPayload.CategoryName})
 		if err != nil {
 			app.errorJSON(w, err)
 			return
 		}
-
-		// write image to /static/banners
-		if err := os.WriteFile(fmt.Sprintf("%s/banners/%s.jpg", staticPath, blog.Slug), decoded, 0666); err != nil {
-			app.errorJSON(w, err)
-			return
-		}
-
-	}
-
-	if blog.ID == 0 {
-		// adding a blog
-		_, err := app.models.Blog.Create(blog)
+	} else {
+		// renaming a category
+		category, err := app.models.Category.GetByID(requestPayload.ID)
 		if err != nil {
 			app.errorJSON(w, err)
 			return
 		}
-	} else {
-		// update a blog
-		err := blog.Update()
+
+		category.CategoryName = requestPayload.CategoryName
+		err = category.Update()
 		if err != nil {
 			app.errorJSON(w, err)
 			return
@@ -393,41 +2199,113 @@ func (app *application) EditBlog(w http.ResponseWriter, r *http.Request) {
 	app.writeJSON(w, http.StatusAccepted, payload)
 }
 
-// get blog by id
-func (app *application) BlogByID(w http.ResponseWriter, r *http.Request) {
-	blogID, err := strconv.Atoi(chi.URLParam(r, "id"))
+// delete a category, a category that is still used by blogs is only deleted when cascade is set
+func (app *application) DeleteCategory(w http.ResponseWriter, r *http.Request) {
+	var requestPayload struct {
+		ID      int  `json:"id"`
+		Cascade bool `json:"cascade"`
+	}
+
+	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	blog, err := app.models.Blog.GetOneById(blogID)
+	err = app.models.Category.DeleteByID(requestPayload.ID, requestPayload.Cascade)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
 	payload := jsonResponse{
-		Error: false,
-		Data:  blog,
+		Error:   false,
+		Message: "Category deleted",
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// Trash lists the blogs and users in the trash
+func (app *application) Trash(w http.ResponseWriter, r *http.Request) {
+	blogs, err := app.models.Blog.GetTrashed()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	users, err := app.models.User.GetTrashed()
+	if err != nil {
+		app.errorJSON(w, err)
+		return
 	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "success",
+		Data:    envelope{"blogs": blogs, "users": users},
+	}
+
 	app.writeJSON(w, http.StatusOK, payload)
+}
 
+// trashPayload is what the trash endpoints get, type is either blog or user
+type trashPayload struct {
+	Type       string `json:"type"`
+	ID         int    `json:"id"`
+	ReassignTo int    `json:"reassign_to"`
 }
 
-// delete blog
-func (app *application) DeleteBlog(w http.ResponseWriter, r *http.Request) {
-	var requestPayload struct {
-		ID int `json:"id"`
+// RestoreFromTrash takes a blog or a user out of the trash
+func (app *application) RestoreFromTrash(w http.ResponseWriter, r *http.Request) {
+	var requestPayload trashPayload
+
+	err := app.readJSON(w, r, &requestPayload)
+	if err != nil {
+		app.errorJSON(w, err)
+		return
 	}
 
-	err := app.readJSON(w, r, &requestPayload.ID)
+	switch requestPayload.Type {
+	case "blog":
+		err = app.models.Blog.Restore(requestPayload.ID)
+	case "user":
+		err = app.models.User.Restore(requestPayload.ID)
+	default:
+		err = errors.New("type must be blog or user")
+	}
+	if err != nil {
+		app.errorJSON(w, err)
+		return
+	}
+
+	payload := jsonResponse{
+		Error:   false,
+		Message: "Restored",
+	}
+
+	app.writeJSON(w, http.StatusOK, payload)
+}
+
+// PurgeFromTrash permanently deletes a blog or a user that is in the trash,
+// a user who still has blogs is only purged when reassign_to is set
+func (app *application) PurgeFromTrash(w http.ResponseWriter, r *http.Request) {
+	var requestPayload trashPayload
+
+	err := app.readJSON(w, r, &requestPayload)
 	if err != nil {
 		app.errorJSON(w, err)
 		return
 	}
 
-	err = app.models.Blog.DeleteByID(requestPayload.ID)
+	switch requestPayload.Type {
+	case "blog":
+		err = app.models.Blog.Purge(requestPayload.ID)
+	case "user":
+		err = app.models.User.Purge(requestPayload.ID, requestPayload.ReassignTo)
+	default:
+		err = errors.New("type must be blog or user")
+	}
 	if err != nil {
 		app.errorJSON(w, err)
 		return
@@ -435,7 +2313,7 @@ func (app *application) DeleteBlog(w http.ResponseWriter, r *http.Request) {
 
 	payload := jsonResponse{
 		Error:   false,
-		Message: "Blog Deleted",
+		Message: "Deleted permanently",
 	}
 
 	app.writeJSON(w, http.StatusOK, payload)
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
+	"thelsblog-server/internal/data"
+	"thelsblog-server/internal/webauthn"
 	"time"
+
+	"github.com/go-chi/chi/v5"
 )
 
 func TestApplication_Allusers(t *testing.T) {
@@ -29,3 +39,265 @@ func TestApplication_Allusers(t *testing.T) {
 		t.Error("AllUsers returned wrong status code of", rr.Code)
 	}
 }
+
+func TestApplication_ResetPassword_shortPassword(t *testing.T) {
+	// a password that is too short is refused before the token is used up, so no query is made
+	body := strings.NewReader(`{"token": "ABCDEFGHIJKLMNOPQRSTUVWXYZ", "password": "short"}`)
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/users/reset-password", body)
+	handler := http.HandlerFunc(testApp.ResetPassword)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusBadRequest {
+		t.Error("ResetPassword returned wrong status code of", rr.Code)
+	}
+}
+
+func TestApplication_Register_invalid(t *testing.T) {
+	// none of these get as far as the database
+	var theTests = []struct {
+		name string
+		body string
+	}{
+		{"bad email", `{"email": "not an email", "first_name": "Jack", "last_name": "Smith", "password": "verysecret"}`},
+		{"email with name", `{"email": "Jack <jack@example.com>", "first_name": "Jack", "last_name": "Smith", "password": "verysecret"}`},
+		{"no name", `{"email": "jack@example.com", "first_name": " ", "last_name": "Smith", "password": "verysecret"}`},
+		{"short password", `{"email": "jack@example.com", "first_name": "Jack", "last_name": "Smith", "password": "short"}`},
+	}
+
+	for _, e := range theTests {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/users/register", strings.NewReader(e.body))
+		handler := http.HandlerFunc(testApp.Register)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("%s: Register returned wrong status code of %d", e.name, rr.Code)
+		}
+	}
+}
+
+func TestApplication_Register_existing(t *testing.T) {
+	// somebody who already has an account gets an email about it, the answer is the same as for a new address
+	mockDB.ExpectQuery("from users where lower\\(email\\)").
+		WillReturnRows(mockDB.NewRows([]string{"id", "email", "first_name", "last_name", "password", "created_at",
+			"updated_at", "user_active", "role", "email_verified_at"}).
+			AddRow(1, "jack@example.com", "Jack", "Smith", "hash", time.Now(), time.Now(), 1, "reader", time.Now()))
+
+	body := `{"email": "Jack@Example.com", "first_name": "Jack", "last_name": "Smith", "password": "verysecret"}`
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/users/register", strings.NewReader(body))
+	handler := http.HandlerFunc(testApp.Register)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusAccepted {
+		t.Error("Register returned wrong status code of", rr.Code)
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApplication_PasskeyLoginFinish_invalid(t *testing.T) {
+	// client data without a challenge is refused, after checking the ip address is not blocked
+	mockDB.ExpectQuery("select max\\(blocked_until\\) from login_throttles").
+		WillReturnRows(mockDB.NewRows([]string{"max"}).AddRow(nil))
+
+	body := strings.NewReader(`{"credential": {"id": "AQID", "rawId": "AQID", "type": "public-key",
+		"response": {"clientDataJSON": "e30", "authenticatorData": "", "signature": ""}}}`)
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/users/passkeys/login/finish", body)
+	handler := http.HandlerFunc(testApp.PasskeyLoginFinish)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Error("PasskeyLoginFinish returned wrong status code of", rr.Code)
+	}
+}
+
+func TestApplication_Login_throttled(t *testing.T) {
+	// the logs of every request go to logs, none of them may have the password or the email address in them
+	var logs bytes.Buffer
+	app := testApp
+	app.infoLog = log.New(&logs, "INFO\t", 0)
+	app.errorLog = log.New(&logs, "ERROR\t", 0)
+
+	// a blocked ip address or account doesn't get its password checked
+	mockDB.ExpectQuery("select max\\(blocked_until\\) from login_throttles").
+		WillReturnRows(mockDB.NewRows([]string{"max"}).AddRow(time.Now().Add(90 * time.Second)))
+	// an unknown email address counts as a failure, the database is gone by the time we count it
+	mockDB.ExpectQuery("select max\\(blocked_until\\) from login_throttles").
+		WillReturnRows(mockDB.NewRows([]string{"max"}).AddRow(nil))
+	mockDB.ExpectQuery("from users where lower\\(email\\)").WillReturnRows(mockDB.NewRows([]string{"id"}))
+
+	var theTests = []struct {
+		name           string
+		body           string
+		expectedStatus int
+	}{
+		{"bad json", `{"email": "locked@example.com", "password": "correct horse battery staple"`, http.StatusBadRequest},
+		{"blocked", `{"email": "locked@example.com", "password": "correct horse battery staple"}`, http.StatusTooManyRequests},
+		{"unknown", `{"email": "locked@example.com", "password": "correct horse battery staple"}`, http.StatusBadRequest},
+	}
+
+	for _, e := range theTests {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/users/login", strings.NewReader(e.body))
+		handler := http.HandlerFunc(app.Login)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatus {
+			t.Errorf("%s: Login returned wrong status code of %d", e.name, rr.Code)
+		}
+
+		if e.expectedStatus == http.StatusTooManyRequests && rr.Header().Get("Retry-After") != "90" {
+			t.Errorf("%s: expected to retry after 90 seconds but got %q", e.name, rr.Header().Get("Retry-After"))
+		}
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+
+	if strings.Contains(logs.String(), "correct horse") || strings.Contains(logs.String(), "locked@example.com") {
+		t.Errorf("credentials were logged: %s", logs.String())
+	}
+}
+
+func TestApplication_PasskeyLoginStart_unknown(t *testing.T) {
+	app := testApp
+	app.webauthn, _ = webauthn.New(webauthn.Config{RPID: "localhost", Origins: []string{"http://localhost:8081"}})
+
+	options := func(email string) webauthn.RequestOptions {
+		// the database doesn't know anybody here
+		mockDB.ExpectQuery("from users where lower\\(email\\)").WillReturnRows(mockDB.NewRows([]string{"id"}))
+
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/users/passkeys/login/start", strings.NewReader(`{"email": "`+email+`"}`))
+		http.HandlerFunc(app.PasskeyLoginStart).ServeHTTP(rr, req)
+
+		var response struct {
+			Data struct {
+				Options webauthn.RequestOptions `json:"options"`
+			} `json:"data"`
+		}
+		if err := json.NewDecoder(rr.Body).Decode(&response); err != nil || rr.Code != http.StatusOK {
+			t.Fatalf("PasskeyLoginStart returned %d: %v", rr.Code, err)
+		}
+		return response.Data.Options
+	}
+
+	first, again, other := options("nobody@example.com"), options("Nobody@example.com"), options("other@example.com")
+
+	// a made up passkey that stays the same, like a real one would
+	if len(first.AllowCredentials) != 1 || !bytes.Equal(first.AllowCredentials[0].ID, again.AllowCredentials[0].ID) {
+		t.Errorf("expected the same made up passkey every time but got %v and %v", first.AllowCredentials, again.AllowCredentials)
+	}
+	if bytes.Equal(first.AllowCredentials[0].ID, other.AllowCredentials[0].ID) {
+		t.Error("expected somebody else to get another made up passkey")
+	}
+
+	// and a challenge that looks like a stored one
+	if len(first.Challenge) != 52 || bytes.Equal(first.Challenge, again.Challenge) {
+		t.Errorf("expected a new challenge like a one time token but got %q", first.Challenge)
+	}
+}
+
+func TestApplication_BlogByID_owner(t *testing.T) {
+	blogRow := func() {
+		// blog 7 is a draft of user 2
+		mockDB.ExpectQuery("from blogs b").WillReturnRows(mockDB.NewRows([]string{"id", "title", "slug", "createdby_id",
+			"description", "content", "status", "publish_at", "published_at", "created_at", "updated_at", "user_id", "first_name"}).
+			AddRow(7, "Draft", "draft", 2, "", "", "draft", nil, nil, time.Now(), time.Now(), 2, "Other"))
+		mockDB.ExpectQuery("from categorys").WillReturnRows(mockDB.NewRows([]string{"id"}))
+	}
+
+	var theTests = []struct {
+		name         string
+		user         *data.User
+		expectedCode int
+	}{
+		{"another author", &data.User{ID: 1, Role: data.RoleAuthor}, http.StatusForbidden},
+		{"the author", &data.User{ID: 2, Role: data.RoleAuthor}, http.StatusOK},
+		{"editor", &data.User{ID: 3, Role: data.RoleEditor}, http.StatusOK},
+	}
+
+	for _, e := range theTests {
+		blogRow()
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", "7")
+
+		req, _ := http.NewRequest("POST", "/admin/blogs/7", nil)
+		req = req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+		req = testApp.contextSetUser(req, e.user)
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(testApp.BlogByID).ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: BlogByID returned wrong status code of %d", e.name, rr.Code)
+		}
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApplication_SetBlogStatus_author(t *testing.T) {
+	// the author of a blog can't take it back once it is out of review, that is for an editor
+	var theTests = []struct {
+		name   string
+		status string
+		to     string
+	}{
+		{"unpublish", "published", "draft"},
+		{"unschedule", "scheduled", "draft"},
+		{"publish", "in_review", "published"},
+		{"archive", "draft", "archived"},
+	}
+
+	for _, e := range theTests {
+		mockDB.ExpectQuery("from blogs b").WillReturnRows(mockDB.NewRows([]string{"id", "title", "slug", "createdby_id",
+			"description", "content", "status", "publish_at", "published_at", "created_at", "updated_at", "user_id", "first_name"}).
+			AddRow(7, "Blog", "blog", 2, "", "", e.status, nil, nil, time.Now(), time.Now(), 2, "Author"))
+		mockDB.ExpectQuery("from categorys").WillReturnRows(mockDB.NewRows([]string{"id"}))
+
+		body := fmt.Sprintf(`{"id": 7, "status": %q}`, e.to)
+		req, _ := http.NewRequest("POST", "/admin/blogs/status", strings.NewReader(body))
+		req = testApp.contextSetUser(req, &data.User{ID: 2, Role: data.RoleAuthor})
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(testApp.SetBlogStatus).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusForbidden {
+			t.Errorf("%s: SetBlogStatus returned wrong status code of %d", e.name, rr.Code)
+		}
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestApplication_PasskeyLoginFinish_blocked(t *testing.T) {
+	// an ip address that failed too often can't try passkeys either
+	mockDB.ExpectQuery("select max\\(blocked_until\\) from login_throttles").
+		WillReturnRows(mockDB.NewRows([]string{"max"}).AddRow(time.Now().Add(time.Minute)))
+
+	body := strings.NewReader(`{"credential": {"id": "AQID", "rawId": "AQID", "type": "public-key",
+		"response": {"clientDataJSON": "e30", "authenticatorData": "", "signature": ""}}}`)
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/users/passkeys/login/finish", body)
+	http.HandlerFunc(testApp.PasskeyLoginFinish).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusTooManyRequests || rr.Header().Get("Retry-After") == "" {
+		t.Errorf("PasskeyLoginFinish returned wrong status code of %d", rr.Code)
+	}
+}
@@ -1,11 +1,18 @@
 package main
 
 import (
+	"database/sql"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"strings"
+	"thelsblog-server/internal/data"
+	"time"
 )
 
 // Handlers to avoid rewriting same code over again
@@ -78,6 +85,25 @@ func (app *application) errorJSON(w http.ResponseWriter, err error, status ...in
 	var customErr error
 
 	switch {
+	case errors.Is(err, data.ErrNotBlogOwner):
+		customErr = err
+		statusCode = http.StatusForbidden
+	case errors.Is(err, data.ErrCategoryInUse), errors.Is(err, data.ErrUserHasBlogs), errors.Is(err, data.ErrDuplicateEmail):
+		customErr = err
+		statusCode = http.StatusConflict
+	case errors.Is(err, data.ErrInvalidRefreshToken), errors.Is(err, data.ErrRefreshTokenReused):
+		customErr = err
+		statusCode = http.StatusUnauthorized
+	case errors.Is(err, data.ErrInvalidTwoFactorCode):
+		customErr = err
+		statusCode = http.StatusUnauthorized
+	case errors.Is(err, data.ErrTwoFactorAlreadyEnabled), errors.Is(err, data.ErrTwoFactorNotStarted),
+		errors.Is(err, data.ErrTwoFactorNotEnabled):
+		customErr = err
+		statusCode = http.StatusConflict
+	case errors.Is(err, sql.ErrNoRows):
+		customErr = errors.New("record not found")
+		statusCode = http.StatusNotFound
 	case strings.Contains(err.Error(), "SQLSTATE 23505"):
 		customErr = errors.New("duplicate value violates unique constraint")
 		statusCode = http.StatusForbidden
@@ -99,3 +125,140 @@ func (app *application) errorJSON(w http.ResponseWriter, err error, status ...in
 
 	return nil
 }
+
+// readInt reads an integer from the query string, returning defaultValue when the key is not there
+func (app *application) readInt(qs url.Values, key string, defaultValue int) (int, error) {
+	s := qs.Get(key)
+	if s == "" {
+		return defaultValue, nil
+	}
+
+	i, err := strconv.Atoi(s)
+	if err != nil {
+		return defaultValue, fmt.Errorf("%s must be an integer value", key)
+	}
+
+	return i, nil
+}
+
+// readPagination reads the page and page_size query string parameters and makes sure they are valid
+func (app *application) readPagination(r *http.Request) (data.Pagination, error) {
+	qs := r.URL.Query()
+
+	var p data.Pagination
+	var err error
+
+	p.Page, err = app.readInt(qs, "page", 1)
+	if err != nil {
+		return p, err
+	}
+
+	p.PageSize, err = app.readInt(qs, "page_size", 20)
+	if err != nil {
+		return p, err
+	}
+
+	return p, p.Validate()
+}
+
+// readDate reads a date from the query string, either as 2006-01-02 or as RFC3339.
+// It returns nil when the key is not there
+func (app *application) readDate(qs url.Values, key string) (*time.Time, error) {
+	s := qs.Get(key)
+	if s == "" {
+		return nil, nil
+	}
+
+	for _, layout := range []string{"2006-01-02", time.RFC3339} {
+		t, err := time.Parse(layout, s)
+		if err == nil {
+			return &t, nil
+		}
+	}
+
+	return nil, fmt.Errorf("%s must be a date like 2006-01-02 or 2006-01-02T15:04:05Z", key)
+}
+
+// readBlogFilters reads the filters of a blog listing from the query string:
+// page, page_size, sort, order, category, author, from and to.
+// A to date without a time includes the whole of that day
+func (app *application) readBlogFilters(r *http.Request) (data.BlogFilters, error) {
+	qs := r.URL.Query()
+
+	var filters data.BlogFilters
+	var err error
+
+	filters.Pagination, err = app.readPagination(r)
+	if err != nil {
+		return filters, err
+	}
+
+	filters.Sort = qs.Get("sort")
+	filters.Order = qs.Get("order")
+	filters.CategorySlug = qs.Get("category")
+
+	filters.AuthorID, err = app.readInt(qs, "author", 0)
+	if err != nil {
+		return filters, err
+	}
+
+	filters.From, err = app.readDate(qs, "from")
+	if err != nil {
+		return filters, err
+	}
+
+	filters.To, err = app.readDate(qs, "to")
+	if err != nil {
+		return filters, err
+	}
+
+	if filters.To != nil && len(qs.Get("to")) == len("2006-01-02") {
+		endOfDay := filters.To.AddDate(0, 0, 1)
+		filters.To = &endOfDay
+	}
+
+	return filters, filters.Validate()
+}
+
+// background runs fn in its own goroutine, for work like sending emails that the client should not wait for.
+// A panic in fn is logged instead of taking the whole api down
+func (app *application) background(fn func()) {
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				app.errorLog.Println(fmt.Errorf("background task panicked: %v", err))
+			}
+		}()
+
+		fn()
+	}()
+}
+
+// humanDuration writes d the way we would in an email, like 1 hour or 48 hours
+func humanDuration(d time.Duration) string {
+	plural := func(n int, unit string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s", unit)
+		}
+		return fmt.Sprintf("%d %ss", n, unit)
+	}
+
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return plural(int(d/time.Hour), "hour")
+	case d >= time.Minute && d%time.Minute == 0:
+		return plural(int(d/time.Minute), "minute")
+	default:
+		return d.String()
+	}
+}
+
+// clientIP returns the ip address the request came from. We don't look at X-Forwarded-For,
+// anybody can send that header, so behind a proxy this is the address of the proxy
+func (app *application) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
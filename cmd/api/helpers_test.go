@@ -2,11 +2,14 @@ package main
 
 import (
 	"bytes"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"thelsblog-server/internal/data"
+	"time"
 )
 
 func Test_readJSON(t *testing.T) {
@@ -98,3 +101,134 @@ func testJSONPayload(t *testing.T, rr *httptest.ResponseRecorder) {
 		t.Error("error set to false in response from errorJSON, and it should be set to true")
 	}
 }
+
+func Test_errorJSON_StatusCodes(t *testing.T) {
+	var theTests = []struct {
+		name         string
+		err          error
+		expectedCode int
+	}{
+		{"not owner", data.ErrNotBlogOwner, http.StatusForbidden},
+		{"category in use", data.ErrCategoryInUse, http.StatusConflict},
+		{"user has blogs", data.ErrUserHasBlogs, http.StatusConflict},
+		{"refresh token reused", data.ErrRefreshTokenReused, http.StatusUnauthorized},
+		{"wrong two factor code", data.ErrInvalidTwoFactorCode, http.StatusUnauthorized},
+		{"two factor already on", data.ErrTwoFactorAlreadyEnabled, http.StatusConflict},
+		{"no rows", sql.ErrNoRows, http.StatusNotFound},
+		{"other", errors.New("some error"), http.StatusBadRequest},
+	}
+
+	for _, e := range theTests {
+		rr := httptest.NewRecorder()
+		_ = testApp.errorJSON(rr, e.err)
+
+		if rr.Code != e.expectedCode {
+			t.Errorf("%s: expected status %d but got %d", e.name, e.expectedCode, rr.Code)
+		}
+	}
+}
+
+func Test_readPagination(t *testing.T) {
+	var theTests = []struct {
+		query       string
+		page        int
+		pageSize    int
+		expectError bool
+	}{
+		{"", 1, 20, false},
+		{"?page=3&page_size=5", 3, 5, false},
+		{"?page=0", 0, 20, true},
+		{"?page_size=1000", 1, 1000, true},
+		{"?page=abc", 1, 20, true},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("GET", "/blogs"+e.query, nil)
+
+		p, err := testApp.readPagination(req)
+		if e.expectError && err == nil {
+			t.Errorf("%s: expected an error but did not get one", e.query)
+		}
+
+		if !e.expectError && err != nil {
+			t.Errorf("%s: did not expect an error but got %v", e.query, err)
+		}
+
+		if !e.expectError && (p.Page != e.page || p.PageSize != e.pageSize) {
+			t.Errorf("%s: expected page %d and page size %d but got %d and %d", e.query, e.page, e.pageSize, p.Page, p.PageSize)
+		}
+	}
+}
+
+func Test_readBlogFilters(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/blogs?sort=title&order=asc&category=horror&author=2&from=2023-01-01&to=2023-01-31", nil)
+
+	filters, err := testApp.readBlogFilters(req)
+	if err != nil {
+		t.Fatal("did not expect an error but got", err)
+	}
+
+	if filters.Sort != "title" || filters.Order != "asc" || filters.CategorySlug != "horror" || filters.AuthorID != 2 {
+		t.Errorf("filters not read correctly: %+v", filters)
+	}
+
+	// a to date without a time includes the whole day
+	if filters.To.Format("2006-01-02") != "2023-02-01" {
+		t.Errorf("expected to to be the start of the next day but got %s", filters.To)
+	}
+
+	badQueries := []string{
+		"?sort=password",
+		"?order=sideways",
+		"?author=me",
+		"?from=yesterday",
+		"?from=2023-02-01&to=2023-01-01",
+	}
+
+	for _, q := range badQueries {
+		req, _ := http.NewRequest("GET", "/blogs"+q, nil)
+		_, err := testApp.readBlogFilters(req)
+		if err == nil {
+			t.Errorf("%s: expected an error but did not get one", q)
+		}
+	}
+}
+
+func Test_humanDuration(t *testing.T) {
+	var theTests = []struct {
+		d        time.Duration
+		expected string
+	}{
+		{time.Hour, "1 hour"},
+		{48 * time.Hour, "48 hours"},
+		{15 * time.Minute, "15 minutes"},
+		{90 * time.Second, "1m30s"},
+	}
+
+	for _, e := range theTests {
+		if got := humanDuration(e.d); got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.d, e.expected, got)
+		}
+	}
+}
+
+func Test_clientIP(t *testing.T) {
+	var theTests = []struct {
+		remoteAddr string
+		expected   string
+	}{
+		{"192.168.1.10:54321", "192.168.1.10"},
+		{"[::1]:8082", "::1"},
+		{"no port", "no port"},
+	}
+
+	for _, e := range theTests {
+		req, _ := http.NewRequest("POST", "/users/login", nil)
+		req.RemoteAddr = e.remoteAddr
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+
+		if got := testApp.clientIP(req); got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.remoteAddr, e.expected, got)
+		}
+	}
+}
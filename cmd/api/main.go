@@ -5,13 +5,63 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"thelsblog-server/internal/data"
 	"thelsblog-server/internal/driver"
+	"thelsblog-server/internal/jwt"
+	"thelsblog-server/internal/mailer"
+	"thelsblog-server/internal/oidc"
+	"thelsblog-server/internal/ratelimit"
+	"thelsblog-server/internal/webauthn"
+	"time"
 )
 
 // Our port for where our server will listen from
 type config struct {
 	port string
+	// how long deleted blogs and users stay in the trash before they are purged
+	trashRetention time.Duration
+	// how long someone who registered has to verify their email address before the account is deleted
+	verificationTTL time.Duration
+	// an access token is what the front end sends with every request, the refresh token gets it a new one
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+	// when jwtKeys is set access tokens are signed JWTs that are checked without the database
+	jwtKeys   string
+	jwtIssuer string
+	// the name authenticator apps show next to the codes for our site
+	totpIssuer string
+	// passkeys are bound to webauthnRPID, the domain of the site, and only work from webauthnOrigins
+	webauthnRPID    string
+	webauthnOrigins []string
+	// the key for the made up passkeys of people without one, random when it is empty
+	webauthnSecret string
+	// how many failed logins an account and an ip address get before they have to wait
+	loginPolicy struct {
+		account data.LoginPolicy
+		ip      data.LoginPolicy
+	}
+	// the limits of the rate limit policies, see RateLimit
+	rateLimits map[string]ratelimit.Limit
+	// the vue front end, links in emails point there
+	frontendURL string
+	// single sign on, it is off when there is no issuer
+	oidc struct {
+		issuer       string
+		clientID     string
+		clientSecret string
+		redirectURL  string
+		// the role of users created when they first log in
+		defaultRole string
+	}
+	smtp struct {
+		host     string
+		port     int
+		username string
+		password string
+		sender   string
+	}
 }
 
 type application struct {
@@ -20,6 +70,11 @@ type application struct {
 	errorLog    *log.Logger
 	db          *driver.DB
 	models      data.Models
+	mailer      mailer.Mailer
+	jwt         *jwt.Signer
+	webauthn    *webauthn.WebAuthn
+	oidc        *oidc.Provider
+	rateLimiter ratelimit.Store
 	environment string
 }
 
@@ -33,6 +88,89 @@ func main() {
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	errorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
 
+	// TRASH_RETENTION is a go duration like 720h, deleted items are purged once they are older than that
+	var err error
+	cfg.trashRetention, err = envDuration("TRASH_RETENTION", 30*24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// VERIFICATION_TTL is a go duration as well
+	cfg.verificationTTL, err = envDuration("VERIFICATION_TTL", 48*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// ACCESS_TOKEN_TTL and REFRESH_TOKEN_TTL too, a session ends when its refresh token is not used for that long
+	cfg.accessTokenTTL, err = envDuration("ACCESS_TOKEN_TTL", 15*time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.refreshTokenTTL, err = envDuration("REFRESH_TOKEN_TTL", 30*24*time.Hour)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// LOGIN_MAX_FAILURES failed logins in a row lock an account for LOGIN_LOCKOUT, before that every failure
+	// after the third doubles the wait. An ip address gets LOGIN_MAX_FAILURES_PER_IP, it can be shared by many people
+	cfg.loginPolicy.account = data.LoginPolicy{FreeAttempts: 3, BaseDelay: time.Second, ForgetAfter: 24 * time.Hour}
+	cfg.loginPolicy.account.MaxFailures, err = envInt("LOGIN_MAX_FAILURES", 10)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.loginPolicy.account.Lockout, err = envDuration("LOGIN_LOCKOUT", 15*time.Minute)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.loginPolicy.ip = cfg.loginPolicy.account
+	cfg.loginPolicy.ip.FreeAttempts = 20
+	cfg.loginPolicy.ip.MaxFailures, err = envInt("LOGIN_MAX_FAILURES_PER_IP", 100)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// RATE_LIMIT_AUTH, RATE_LIMIT_READ, RATE_LIMIT_ADMIN and RATE_LIMIT_ADMIN_IP are requests/period or requests/period/burst
+	cfg.rateLimits = make(map[string]ratelimit.Limit)
+	for policy, fallback := range map[string]string{rateLimitAuth: "10/1m", rateLimitRead: "300/1m/60",
+		rateLimitAdmin: "120/1m", rateLimitAdminIP: "600/1m"} {
+		cfg.rateLimits[policy], err = ratelimit.ParseLimit(envString("RATE_LIMIT_"+strings.ToUpper(policy), fallback))
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	// JWT_KEYS is a list of kid:algorithm:base64 keys, see jwt.ParseKeys. To rotate, put the new key first
+	// and remove the old one once the access tokens it signed have expired
+	cfg.jwtKeys = os.Getenv("JWT_KEYS")
+	cfg.jwtIssuer = envString("JWT_ISSUER", "thelsblog")
+	cfg.totpIssuer = envString("TOTP_ISSUER", "ThelsBlog")
+
+	cfg.frontendURL = envString("FRONTEND_URL", "http://localhost:8081")
+
+	// WEBAUTHN_ORIGINS is a comma separated list, by default passkeys only work from the front end
+	cfg.webauthnRPID = envString("WEBAUTHN_RP_ID", "localhost")
+	cfg.webauthnOrigins = strings.Split(envString("WEBAUTHN_ORIGINS", cfg.frontendURL), ",")
+	cfg.webauthnSecret = os.Getenv("WEBAUTHN_SECRET")
+
+	cfg.oidc.issuer = os.Getenv("OIDC_ISSUER")
+	cfg.oidc.clientID = os.Getenv("OIDC_CLIENT_ID")
+	cfg.oidc.clientSecret = os.Getenv("OIDC_CLIENT_SECRET")
+	cfg.oidc.redirectURL = envString("OIDC_REDIRECT_URL", "http://localhost:8082/auth/oidc/callback")
+	cfg.oidc.defaultRole = envString("OIDC_DEFAULT_ROLE", data.RoleReader)
+	if !data.ValidRole(cfg.oidc.defaultRole) {
+		log.Fatalf("OIDC_DEFAULT_ROLE %q is not a role", cfg.oidc.defaultRole)
+	}
+
+	// the defaults send mail to MailHog from docker-compose.yml
+	cfg.smtp.host = envString("SMTP_HOST", "localhost")
+	cfg.smtp.port, err = envInt("SMTP_PORT", 1025)
+	if err != nil {
+		log.Fatal(err)
+	}
+	cfg.smtp.username = os.Getenv("SMTP_USERNAME")
+	cfg.smtp.password = os.Getenv("SMTP_PASSWORD")
+	cfg.smtp.sender = envString("SMTP_SENDER", "ThelsBlog <no-reply@thelsblog.com>")
+
 	//data source name for our database
 	dsn := os.Getenv("DSN")
 	environment := os.Getenv("ENV")
@@ -45,15 +183,76 @@ func main() {
 	//some connections may refuse to close even after exiting, this should handle that
 	defer db.SQL.Close()
 
+	// "thelsblog migrate up|down [steps]" only migrates the database and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		err = runMigrate(db.SQL, infoLog, os.Args[2:])
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+		return
+	}
+
+	// otherwise bring the schema up to date before we start serving
+	migrations, err := data.MigrateUp(db.SQL)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+	logMigrations(infoLog, "up", migrations)
+
+	signer, err := newSigner(cfg.jwtIssuer, cfg.jwtKeys)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	passkeys, err := webauthn.New(webauthn.Config{
+		RPID:    cfg.webauthnRPID,
+		RPName:  cfg.totpIssuer,
+		Origins: cfg.webauthnOrigins,
+		Secret:  []byte(cfg.webauthnSecret),
+	})
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
+	var provider *oidc.Provider
+	if cfg.oidc.issuer != "" {
+		provider, err = oidc.New(oidc.Config{
+			Issuer:       cfg.oidc.issuer,
+			ClientID:     cfg.oidc.clientID,
+			ClientSecret: cfg.oidc.clientSecret,
+			RedirectURL:  cfg.oidc.redirectURL,
+			Scopes:       []string{"email", "profile"},
+		}, nil)
+		if err != nil {
+			errorLog.Fatal(err)
+		}
+	}
+
+	mail, err := mailer.New(cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender)
+	if err != nil {
+		errorLog.Fatal(err)
+	}
+
 	// initializing our application struct
 	app := &application{
-		config:      cfg,
-		infoLog:     infoLog,
-		errorLog:    errorLog,
-		models:      data.New(db.SQL),
+		config:   cfg,
+		infoLog:  infoLog,
+		errorLog: errorLog,
+		models:   data.New(db.SQL),
+		mailer:   mail,
+		jwt:      signer,
+		webauthn: passkeys,
+		oidc:     provider,
+		// one server, so the buckets can stay in memory
+		rateLimiter: ratelimit.NewMemoryStore(),
 		environment: environment,
 	}
 
+	// start the background jobs, like publishing scheduled blogs
+	done := make(chan struct{})
+	defer close(done)
+	app.startScheduler(done)
+
 	// start the webserver
 	err = app.serve()
 	if err != nil {
@@ -72,3 +271,57 @@ func (app *application) serve() error {
 	}
 	return srv.ListenAndServe()
 }
+
+// newSigner returns the signer for JWT access tokens, or nil when no keys are configured and JWT mode is off
+func newSigner(issuer, keySpec string) (*jwt.Signer, error) {
+	keys, err := jwt.ParseKeys(keySpec)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return nil, nil
+	}
+
+	return jwt.New(issuer, keys)
+}
+
+// envDuration reads a duration like 15m or 720h from the environment variable name,
+// fallback is used when it is not set
+func envDuration(name string, fallback time.Duration) (time.Duration, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("%s must be a positive duration like 720h, got %q", name, value)
+	}
+
+	return d, nil
+}
+
+// envString reads the environment variable name, fallback is used when it is not set
+func envString(name, fallback string) string {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback
+	}
+	return value
+}
+
+// envInt reads a number from the environment variable name, fallback is used when it is not set
+func envInt(name string, fallback int) (int, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return fallback, nil
+	}
+
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be a number, got %q", name, value)
+	}
+
+	return i, nil
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func Test_envDuration(t *testing.T) {
+	var theTests = []struct {
+		name     string
+		value    string
+		expected time.Duration
+		errorExp bool
+	}{
+		{"not set", "", time.Hour, false},
+		{"valid", "720h", 720 * time.Hour, false},
+		{"invalid", "a month", 0, true},
+		{"negative", "-1h", 0, true},
+	}
+
+	for _, e := range theTests {
+		t.Setenv("TEST_DURATION", e.value)
+		if e.value == "" {
+			os.Unsetenv("TEST_DURATION")
+		}
+
+		d, err := envDuration("TEST_DURATION", time.Hour)
+		if e.errorExp && err == nil {
+			t.Errorf("%s: expected an error but got none", e.name)
+		}
+		if !e.errorExp && err != nil {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if d != e.expected {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expected, d)
+		}
+	}
+}
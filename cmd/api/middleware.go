@@ -1,11 +1,58 @@
 package main
 
-import "net/http"
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"thelsblog-server/internal/data"
+	"time"
+)
+
+// contextKey is our own type for request context keys so they can't collide with keys from other packages
+type contextKey string
+
+const userContextKey = contextKey("user")
+const tokenContextKey = contextKey("token")
+
+// contextSetUser returns a copy of the request with the authenticated user added to its context
+func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
+	ctx := context.WithValue(r.Context(), userContextKey, user)
+	return r.WithContext(ctx)
+}
+
+// contextGetUser gets the authenticated user from the request context, it returns nil
+// when the request did not go through AuthTokenMiddleware
+func (app *application) contextGetUser(r *http.Request) *data.User {
+	user, ok := r.Context().Value(userContextKey).(*data.User)
+	if !ok {
+		return nil
+	}
+	return user
+}
+
+// contextSetToken returns a copy of the request with the token of the current session added to its context
+func (app *application) contextSetToken(r *http.Request, token *data.Token) *http.Request {
+	ctx := context.WithValue(r.Context(), tokenContextKey, token)
+	return r.WithContext(ctx)
+}
+
+// contextGetToken gets the token of the current session from the request context, it returns nil
+// when the request did not go through AuthTokenMiddleware
+func (app *application) contextGetToken(r *http.Request) *data.Token {
+	token, ok := r.Context().Value(tokenContextKey).(*data.Token)
+	if !ok {
+		return nil
+	}
+	return token
+}
 
 // Middleware for protecting route
+// the authenticated user is put in the request context so handlers further down can get it with contextGetUser,
+// and the token of the session with contextGetToken
 func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		_, err := app.models.Token.AuthenticateToken(r)
+		user, token, err := app.authenticate(r)
 		if err != nil {
 			payload := jsonResponse{
 				Error:   true,
@@ -15,6 +62,101 @@ func (app *application) AuthTokenMiddleware(next http.Handler) http.Handler {
 			_ = app.writeJSON(w, http.StatusUnauthorized, payload)
 			return
 		}
-		next.ServeHTTP(w, r)
+		r = app.contextSetUser(r, user)
+		next.ServeHTTP(w, app.contextSetToken(r, token))
 	})
 }
+
+// RequireRole only lets a request through if the authenticated user has one of the given roles,
+// it has to be used after AuthTokenMiddleware
+func (app *application) RequireRole(roles ...string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user := app.contextGetUser(r)
+			if user == nil {
+				payload := jsonResponse{
+					Error:   true,
+					Message: "invalid authentication credentials",
+				}
+
+				_ = app.writeJSON(w, http.StatusUnauthorized, payload)
+				return
+			}
+
+			if !user.HasRole(roles...) {
+				payload := jsonResponse{
+					Error:   true,
+					Message: "you do not have permission to access this resource",
+				}
+
+				_ = app.writeJSON(w, http.StatusForbidden, payload)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// the rate limits routes can have, the limits themselves are in the config
+const (
+	// logging in and everything else where somebody could guess a password or a token
+	rateLimitAuth = "auth"
+	// reading blogs, anybody can do that so it is the loosest
+	rateLimitRead = "read"
+	// everything behind /admin, counted per user instead of per ip address
+	rateLimitAdmin = "admin"
+	// and per ip address before the token is checked, so made up tokens can't flood the database
+	rateLimitAdminIP = "admin_ip"
+)
+
+// RateLimit limits how often a client can use the routes with the rate limit policy. Clients are users when the
+// request was authenticated before, so use it after AuthTokenMiddleware for that, and ip addresses otherwise.
+// Every response gets RateLimit-* headers, a request over the limit gets 429 with Retry-After
+func (app *application) RateLimit(policy string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			limit, ok := app.config.rateLimits[policy]
+			if !ok || app.rateLimiter == nil {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			key := policy + ":ip:" + app.clientIP(r)
+			if user := app.contextGetUser(r); user != nil {
+				key = policy + ":user:" + strconv.Itoa(user.ID)
+			}
+
+			result, err := app.rateLimiter.Take(r.Context(), key, limit)
+			if err != nil {
+				// we'd rather serve too much than nothing when the store is down
+				app.errorLog.Println(err)
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			w.Header().Set("RateLimit-Policy", limit.Policy())
+			w.Header().Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+			w.Header().Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+			w.Header().Set("RateLimit-Reset", strconv.Itoa(seconds(result.Reset)))
+
+			if !result.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(seconds(result.RetryAfter)))
+
+				payload := jsonResponse{
+					Error:   true,
+					Message: "too many requests, try again later",
+				}
+
+				_ = app.writeJSON(w, http.StatusTooManyRequests, payload)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// seconds rounds d up to whole seconds for headers, a client that waits that long is sure to get through
+func seconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
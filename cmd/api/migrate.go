@@ -0,0 +1,56 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"strconv"
+	"thelsblog-server/internal/data"
+)
+
+// migrateUsage is printed when the migrate subcommand is used the wrong way
+const migrateUsage = "usage: thelsblog migrate up | migrate down [steps]"
+
+// runMigrate runs the migrate subcommand:
+//
+//	migrate up           applies every migration that has not been applied yet
+//	migrate down [steps] rolls back the last steps migrations, one if steps is left out
+func runMigrate(db *sql.DB, infoLog *log.Logger, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	var migrations []data.Migration
+	var err error
+
+	switch args[0] {
+	case "up":
+		migrations, err = data.MigrateUp(db)
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			steps, err = strconv.Atoi(args[1])
+			if err != nil || steps < 1 {
+				return errors.New(migrateUsage)
+			}
+		}
+		migrations, err = data.MigrateDown(db, steps)
+	default:
+		return errors.New(migrateUsage)
+	}
+
+	logMigrations(infoLog, args[0], migrations)
+	return err
+}
+
+// logMigrations writes one line per migration that was run, or one line saying there was nothing to do
+func logMigrations(infoLog *log.Logger, direction string, migrations []data.Migration) {
+	if len(migrations) == 0 {
+		infoLog.Printf("migrate %s: nothing to do", direction)
+		return
+	}
+
+	for _, m := range migrations {
+		infoLog.Printf("migrate %s: %06d_%s", direction, m.Version, m.Name)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import "testing"
+
+func Test_runMigrate_usage(t *testing.T) {
+	// none of these get as far as the database
+	var theTests = [][]string{
+		nil,
+		{"sideways"},
+		{"down", "zero"},
+		{"down", "0"},
+	}
+
+	for _, args := range theTests {
+		err := runMigrate(nil, testApp.infoLog, args)
+		if err == nil || err.Error() != migrateUsage {
+			t.Errorf("%v: expected the usage error but got %v", args, err)
+		}
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"thelsblog-server/internal/data"
+	"thelsblog-server/internal/oidc"
+	"thelsblog-server/internal/oidc/oidctest"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+)
+
+// oidcApp is testApp with single sign on against a mock provider
+func oidcApp(t *testing.T) application {
+	mock := oidctest.NewProvider("thelsblog", "secret")
+	t.Cleanup(mock.Close)
+
+	app := testApp
+	app.config.frontendURL = "http://localhost:8081"
+	app.config.oidc.redirectURL = "http://localhost:8082/auth/oidc/callback"
+
+	var err error
+	app.oidc, err = oidc.New(oidc.Config{Issuer: mock.URL, ClientID: "thelsblog", ClientSecret: "secret",
+		RedirectURL: app.config.oidc.redirectURL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return app
+}
+
+func TestApplication_OIDCStart(t *testing.T) {
+	// without an issuer single sign on is off
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/auth/oidc/start", nil)
+	http.HandlerFunc(testApp.OIDCStart).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusNotFound {
+		t.Errorf("expected status 404 without single sign on but got %d", rr.Code)
+	}
+
+	app := oidcApp(t)
+
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(app.OIDCStart).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusFound {
+		t.Fatalf("expected a redirect to the provider but got %d", rr.Code)
+	}
+
+	location, _ := url.Parse(rr.Header().Get("Location"))
+	q := location.Query()
+	if !strings.HasSuffix(location.Path, "/authorize") || q.Get("code_challenge_method") != "S256" || q.Get("nonce") == "" {
+		t.Errorf("expected an authorization request with PKCE and a nonce but got %s", location)
+	}
+
+	cookies := rr.Result().Cookies()
+	if len(cookies) != 1 || !cookies[0].HttpOnly || strings.Split(cookies[0].Value, ".")[0] != q.Get("state") {
+		t.Errorf("expected an http only cookie with the state but got %v", cookies)
+	}
+}
+
+func TestApplication_OIDCCallback_invalid(t *testing.T) {
+	app := oidcApp(t)
+
+	var theTests = []struct {
+		name   string
+		query  string
+		cookie string
+	}{
+		{"no cookie", "?code=abc&state=s1", ""},
+		{"wrong state", "?code=abc&state=s2", "s1.n.v"},
+		{"provider error", "?error=access_denied&state=s1", "s1.n.v"},
+		{"unknown code", "?code=abc&state=s1", "s1.n.v"},
+	}
+
+	for _, e := range theTests {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/auth/oidc/callback"+e.query, nil)
+		if e.cookie != "" {
+			req.AddCookie(&http.Cookie{Name: oidcCookieName, Value: e.cookie})
+		}
+		http.HandlerFunc(app.OIDCCallback).ServeHTTP(rr, req)
+
+		location, _ := url.Parse(rr.Header().Get("Location"))
+		if rr.Code != http.StatusFound || location.Path != "/login/sso" || location.Query().Get("error") == "" {
+			t.Errorf("%s: expected a redirect to the front end with an error but got %d %s", e.name, rr.Code, location)
+		}
+	}
+}
+
+func TestApplication_OIDCSession_twoFactor(t *testing.T) {
+	// the identity provider vouched for an admin with two factor authentication, they still need their code
+	mockDB.ExpectQuery("update one_time_tokens set used_at").WillReturnRows(mockDB.NewRows([]string{"user_id"}).AddRow(1))
+	mockDB.ExpectQuery("from users where id").WillReturnRows(mockDB.NewRows([]string{"id", "email", "first_name", "last_name",
+		"password", "user_active", "role", "created_at", "updated_at"}).
+		AddRow(1, "admin@example.com", "Admin", "User", "", 1, data.RoleAdmin, time.Now(), time.Now()))
+	mockDB.ExpectQuery("select totp_enabled_at").WillReturnRows(mockDB.NewRows([]string{"totp_enabled_at", "count"}).AddRow(time.Now(), 10))
+	mockDB.ExpectBegin()
+	mockDB.ExpectExec("delete from one_time_tokens").WillReturnResult(sqlmock.NewResult(0, 0))
+	mockDB.ExpectQuery("insert into one_time_tokens").WillReturnRows(mockDB.NewRows([]string{"id"}).AddRow(1))
+	mockDB.ExpectCommit()
+
+	rr := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/auth/oidc/session", strings.NewReader(`{"code": "ABCDEFGH"}`))
+	http.HandlerFunc(testApp.OIDCSession).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || !strings.Contains(rr.Body.String(), `"two_factor_required": true`) {
+		t.Errorf("expected a two factor challenge but got %d %s", rr.Code, rr.Body.String())
+	}
+	if strings.Contains(rr.Body.String(), "refresh_token") {
+		t.Error("expected no session before the second factor")
+	}
+
+	if err := mockDB.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
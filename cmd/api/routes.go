@@ -5,6 +5,7 @@ package main
 
 import (
 	"net/http"
+	"thelsblog-server/internal/data"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -31,31 +32,109 @@ func (app *application) routes() http.Handler {
 		MaxAge:           300,
 	}))
 
-	mux.Post("/users/login", app.Login)
-	mux.Post("/users/logout", app.Logout)
-	mux.Post("/validate-token", app.ValidateToken)
-	//mux.Post("/blogs", app.AllBlogs)
+	// everything that takes a password or a token gets the strictest rate limit, per ip address
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.RateLimit(rateLimitAuth))
 
-	mux.Get("/blogs", app.AllBlogs)
-	mux.Get("/blogs/{slug}", app.OneBlog)
+		mux.Post("/users/login", app.Login)
+		mux.Post("/users/login/2fa", app.LoginTwoFactor)
+		mux.Post("/users/passkeys/login/start", app.PasskeyLoginStart)
+		mux.Post("/users/passkeys/login/finish", app.PasskeyLoginFinish)
+		mux.Post("/users/refresh", app.Refresh)
+		mux.Post("/users/logout", app.Logout)
+		mux.Post("/users/register", app.Register)
+		mux.Post("/users/verify-email", app.VerifyEmail)
+		mux.Post("/users/forgot-password", app.ForgotPassword)
+		mux.Post("/users/reset-password", app.ResetPassword)
+		mux.Post("/validate-token", app.ValidateToken)
+
+		// single sign on with the identity provider, start and callback are opened by the browser
+		mux.Get("/auth/oidc/start", app.OIDCStart)
+		mux.Get("/auth/oidc/callback", app.OIDCCallback)
+		mux.Post("/auth/oidc/session", app.OIDCSession)
+	})
+
+	mux.Group(func(mux chi.Router) {
+		mux.Use(app.RateLimit(rateLimitRead))
+
+		mux.Get("/.well-known/jwks.json", app.JWKS)
+		//mux.Post("/blogs", app.AllBlogs)
+
+		mux.Get("/blogs", app.AllBlogs)
+		mux.Get("/blogs/search", app.SearchBlogs)
+		mux.Get("/blogs/{slug}", app.OneBlog)
+		mux.Get("/categories", app.AllCategories)
+		mux.Get("/categories/{slug}/blogs", app.CategoryBlogs)
+		mux.Get("/authors/{id}/blogs", app.AuthorBlogs)
+	})
 
 	// protected routes
 	// use AuthTokenMiddleware meaning all the users need to have a token to be able to access them
 	// all the routes inside the block are prefix with /admin
 	mux.Route("/admin", func(mux chi.Router) {
-		// This is for protecting our route but it doesnt allow vue have acess to it so uncomment later when u find a solution
-		//mux.Use(app.AuthTokenMiddleware)
+		// before AuthTokenMiddleware the limit is per ip address, so requests with made up tokens are limited too,
+		// after it every user has their own limit
+		mux.Use(app.RateLimit(rateLimitAdminIP))
+		mux.Use(app.AuthTokenMiddleware)
+		mux.Use(app.RateLimit(rateLimitAdmin))
+
+		// everybody who is logged in can manage their own sessions
+		mux.Post("/sessions", app.Sessions)
+		mux.Post("/sessions/revoke", app.RevokeSession)
+		mux.Post("/sessions/revoke-others", app.RevokeOtherSessions)
 
-		mux.Post("/users", app.AllUsers)
-		mux.Post("/users/save", app.EditUser)
-		mux.Post("/users/get/{id}", app.GetUser)
-		mux.Post("/users/delete", app.DeleteUser)
-		mux.Post("/log-user-out/{id}", app.LogUserOutAndSetInactive)
+		// and their two factor authentication
+		mux.Post("/2fa", app.TwoFactorStatus)
+		mux.Post("/2fa/enroll", app.EnrollTwoFactor)
+		mux.Post("/2fa/confirm", app.ConfirmTwoFactor)
+		mux.Post("/2fa/disable", app.DisableTwoFactor)
+		mux.Post("/2fa/recovery-codes", app.RegenerateRecoveryCodes)
+
+		// and their passkeys
+		mux.Post("/passkeys", app.Passkeys)
+		mux.Post("/passkeys/register/start", app.PasskeyRegisterStart)
+		mux.Post("/passkeys/register/finish", app.PasskeyRegisterFinish)
+		mux.Post("/passkeys/delete", app.DeletePasskey)
+
+		// only admins can manage users
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.RequireRole(data.RoleAdmin))
+
+			mux.Post("/users", app.AllUsers)
+			mux.Post("/users/save", app.EditUser)
+			mux.Post("/users/get/{id}", app.GetUser)
+			mux.Post("/users/delete", app.DeleteUser)
+			mux.Post("/users/unlock", app.UnlockUser)
+			mux.Post("/log-user-out/{id}", app.LogUserOutAndSetInactive)
+
+			// deleted blogs and users end up in the trash until they are restored or purged
+			mux.Post("/trash", app.Trash)
+			mux.Post("/trash/restore", app.RestoreFromTrash)
+			mux.Post("/trash/purge", app.PurgeFromTrash)
+		})
 
 		//admin blog routes
-		mux.Post("/blogs/save", app.EditBlog)
-		mux.Post("/blogs/{id}", app.BlogByID)
-		mux.Post("/blogs/delete", app.DeleteBlog)
+		// authors can get here too but the handlers make sure they only touch their own blogs
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.RequireRole(data.RoleAdmin, data.RoleEditor, data.RoleAuthor))
+
+			mux.Post("/blogs", app.AdminAllBlogs)
+			mux.Post("/blogs/save", app.EditBlog)
+			mux.Post("/blogs/status", app.SetBlogStatus)
+			mux.Post("/blogs/{id}", app.BlogByID)
+			mux.Post("/blogs/delete", app.DeleteBlog)
+			mux.Post("/blogs/{id}/revisions", app.BlogRevisions)
+			mux.Post("/blogs/{id}/revisions/diff", app.BlogRevisionDiff)
+			mux.Post("/blogs/{id}/revisions/restore", app.RestoreBlogRevision)
+		})
+
+		// categories are managed by editors and admins
+		mux.Group(func(mux chi.Router) {
+			mux.Use(app.RequireRole(data.RoleAdmin, data.RoleEditor))
+
+			mux.Post("/categories/save", app.EditCategory)
+			mux.Post("/categories/delete", app.DeleteCategory)
+		})
 
 	})
 
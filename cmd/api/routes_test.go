@@ -13,11 +13,49 @@ func Test_Routes_Exist(t *testing.T) {
 
 	// these routes must exist
 	routeExists(t, chiRoutes, "/users/login")
+	routeExists(t, chiRoutes, "/users/login/2fa")
+	routeExists(t, chiRoutes, "/users/passkeys/login/start")
+	routeExists(t, chiRoutes, "/users/passkeys/login/finish")
+	routeExists(t, chiRoutes, "/auth/oidc/start")
+	routeExists(t, chiRoutes, "/auth/oidc/callback")
+	routeExists(t, chiRoutes, "/auth/oidc/session")
+	routeExists(t, chiRoutes, "/users/refresh")
+	routeExists(t, chiRoutes, "/.well-known/jwks.json")
 	routeExists(t, chiRoutes, "/users/logout")
+	routeExists(t, chiRoutes, "/users/register")
+	routeExists(t, chiRoutes, "/users/verify-email")
+	routeExists(t, chiRoutes, "/users/forgot-password")
+	routeExists(t, chiRoutes, "/users/reset-password")
 	routeExists(t, chiRoutes, "/admin/users")
 	routeExists(t, chiRoutes, "/admin/users/get/{id}")
 	routeExists(t, chiRoutes, "/admin/users/save")
 	routeExists(t, chiRoutes, "/admin/users/delete")
+	routeExists(t, chiRoutes, "/admin/users/unlock")
+	routeExists(t, chiRoutes, "/admin/sessions")
+	routeExists(t, chiRoutes, "/admin/sessions/revoke")
+	routeExists(t, chiRoutes, "/admin/sessions/revoke-others")
+	routeExists(t, chiRoutes, "/admin/2fa")
+	routeExists(t, chiRoutes, "/admin/2fa/enroll")
+	routeExists(t, chiRoutes, "/admin/2fa/confirm")
+	routeExists(t, chiRoutes, "/admin/2fa/disable")
+	routeExists(t, chiRoutes, "/admin/2fa/recovery-codes")
+	routeExists(t, chiRoutes, "/admin/passkeys")
+	routeExists(t, chiRoutes, "/admin/passkeys/register/start")
+	routeExists(t, chiRoutes, "/admin/passkeys/register/finish")
+	routeExists(t, chiRoutes, "/admin/passkeys/delete")
+	routeExists(t, chiRoutes, "/admin/trash")
+	routeExists(t, chiRoutes, "/admin/trash/restore")
+	routeExists(t, chiRoutes, "/admin/trash/purge")
+	routeExists(t, chiRoutes, "/blogs/search")
+	routeExists(t, chiRoutes, "/categories")
+	routeExists(t, chiRoutes, "/categories/{slug}/blogs")
+	routeExists(t, chiRoutes, "/authors/{id}/blogs")
+	routeExists(t, chiRoutes, "/admin/categories/save")
+	routeExists(t, chiRoutes, "/admin/categories/delete")
+	routeExists(t, chiRoutes, "/admin/blogs/status")
+	routeExists(t, chiRoutes, "/admin/blogs/{id}/revisions")
+	routeExists(t, chiRoutes, "/admin/blogs/{id}/revisions/diff")
+	routeExists(t, chiRoutes, "/admin/blogs/{id}/revisions/restore")
 }
 
 func routeExists(t *testing.T, routes chi.Router, route string) {
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// job is a task the scheduler runs in the background every interval
+type job struct {
+	name     string
+	interval time.Duration
+	run      func() error
+}
+
+// jobs returns all the background jobs of the api
+func (app *application) jobs() []job {
+	return []job{
+		{name: "publish scheduled blogs", interval: time.Minute, run: app.publishScheduledBlogs},
+		{name: "purge trash", interval: time.Hour, run: app.purgeTrash},
+		{name: "delete unverified users", interval: time.Hour, run: app.deleteUnverifiedUsers},
+	}
+}
+
+// startScheduler starts one goroutine per job, they all stop once done is closed
+func (app *application) startScheduler(done <-chan struct{}) {
+	for _, j := range app.jobs() {
+		go app.runJob(j, done)
+	}
+}
+
+// runJob runs j every j.interval until done is closed
+func (app *application) runJob(j job, done <-chan struct{}) {
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			app.runJobOnce(j)
+		}
+	}
+}
+
+// runJobOnce runs j a single time, a panic in a job is logged instead of taking the whole api down
+func (app *application) runJobOnce(j job) {
+	defer func() {
+		if err := recover(); err != nil {
+			app.errorLog.Println(fmt.Errorf("job %q panicked: %v", j.name, err))
+		}
+	}()
+
+	err := j.run()
+	if err != nil {
+		app.errorLog.Printf("job %q failed: %v", j.name, err)
+	}
+}
+
+// publishScheduledBlogs flips scheduled blogs to published once their time arrives
+func (app *application) publishScheduledBlogs() error {
+	n, err := app.models.Blog.PublishScheduled()
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.infoLog.Printf("published %d scheduled blog(s)", n)
+	}
+
+	return nil
+}
+
+// purgeTrash permanently deletes the blogs and users that have been in the trash for longer than the retention period
+func (app *application) purgeTrash() error {
+	before := time.Now().Add(-app.config.trashRetention)
+
+	blogs, err := app.models.Blog.PurgeTrashed(before)
+	if err != nil {
+		return err
+	}
+
+	// blogs first, so users whose last blogs were just purged can go too
+	users, err := app.models.User.PurgeTrashed(before)
+	if err != nil {
+		return err
+	}
+
+	if blogs > 0 || users > 0 {
+		app.infoLog.Printf("purged %d blog(s) and %d user(s) from the trash", blogs, users)
+	}
+
+	return nil
+}
+
+// deleteUnverifiedUsers deletes the accounts of people who registered but did not verify their email address in time
+func (app *application) deleteUnverifiedUsers() error {
+	n, err := app.models.User.DeleteUnverified(time.Now().Add(-app.config.verificationTTL))
+	if err != nil {
+		return err
+	}
+
+	if n > 0 {
+		app.infoLog.Printf("deleted %d unverified user(s)", n)
+	}
+
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func Test_runJobOnce(t *testing.T) {
+	// a job that panics must not take the api down with it
+	testApp.runJobOnce(job{name: "panics", run: func() error {
+		panic("boom")
+	}})
+
+	testApp.runJobOnce(job{name: "fails", run: func() error {
+		return errors.New("some error")
+	}})
+}
+
+func Test_runJob(t *testing.T) {
+	ran := make(chan struct{}, 10)
+	done := make(chan struct{})
+
+	go testApp.runJob(job{name: "test", interval: time.Millisecond, run: func() error {
+		ran <- struct{}{}
+		return nil
+	}}, done)
+
+	select {
+	case <-ran:
+	case <-time.After(time.Second):
+		t.Error("job did not run")
+	}
+
+	close(done)
+}
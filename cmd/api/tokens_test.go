@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"thelsblog-server/internal/data"
+	"thelsblog-server/internal/jwt"
+	"time"
+)
+
+// jwtApp is testApp in JWT mode
+func jwtApp(t *testing.T) application {
+	key, err := jwt.NewEd25519Key("test", bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := jwt.New("thelsblog", []jwt.Key{key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := testApp
+	app.jwt = signer
+	app.config.accessTokenTTL = time.Minute
+	return app
+}
+
+func Test_AuthTokenMiddleware_JWT(t *testing.T) {
+	app := jwtApp(t)
+
+	user := &data.User{ID: 7, Email: "editor@example.com", Role: data.RoleEditor}
+	token, err := app.accessToken(user, nil, "family-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var gotUser *data.User
+	var gotToken *data.Token
+	handler := app.AuthTokenMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotUser = app.contextGetUser(r)
+		gotToken = app.contextGetToken(r)
+	}))
+
+	// the mock database has no expectations, so this only works when the database is left alone
+	req, _ := http.NewRequest("POST", "/admin/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+token.Token)
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected status 200 but got %d", rr.Code)
+	}
+	if gotUser.ID != 7 || gotUser.Role != data.RoleEditor || gotToken.Family != "family-1" {
+		t.Errorf("got the wrong user or session from the token: %+v %+v", gotUser, gotToken)
+	}
+
+	// a token signed by somebody else is not accepted
+	other := jwtApp(t)
+	otherKey, _ := jwt.NewHS256Key("test", bytes.Repeat([]byte{2}, 32))
+	other.jwt, _ = jwt.New("thelsblog", []jwt.Key{otherKey})
+	forged, _ := other.accessToken(user, nil, "family-1")
+
+	req, _ = http.NewRequest("POST", "/admin/sessions", nil)
+	req.Header.Set("Authorization", "Bearer "+forged.Token)
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusUnauthorized {
+		t.Errorf("expected status 401 for a forged token but got %d", rr.Code)
+	}
+}
+
+func Test_application_JWKS(t *testing.T) {
+	for _, e := range []struct {
+		name     string
+		app      application
+		expected int
+	}{
+		{"jwt mode off", testApp, 0},
+		{"jwt mode on", jwtApp(t), 1},
+	} {
+		rr := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", "/.well-known/jwks.json", nil)
+		http.HandlerFunc(e.app.JWKS).ServeHTTP(rr, req)
+
+		var jwks jwt.JWKS
+		if err := json.Unmarshal(rr.Body.Bytes(), &jwks); err != nil {
+			t.Fatalf("%s: %v", e.name, err)
+		}
+
+		if rr.Code != http.StatusOK || len(jwks.Keys) != e.expected {
+			t.Errorf("%s: expected status 200 and %d keys but got %d and %d keys", e.name, e.expected, rr.Code, len(jwks.Keys))
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jackc/pgx/v4/stdlib"
+)
+
+// queryCount is the number of queries sent through the pgx-counting driver
+var queryCount int64
+
+func init() {
+	sql.Register("pgx-counting", countingDriver{stdlib.GetDefaultDriver()})
+}
+
+// countingDriver wraps the pgx driver and counts every query sent through it
+type countingDriver struct {
+	driver.Driver
+}
+
+func (d countingDriver) Open(name string) (driver.Conn, error) {
+	conn, err := d.Driver.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return countingConn{conn}, nil
+}
+
+type countingConn struct {
+	driver.Conn
+}
+
+func (c countingConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	atomic.AddInt64(&queryCount, 1)
+	return c.Conn.(driver.QueryerContext).QueryContext(ctx, query, args)
+}
+
+func (c countingConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	return c.Conn.(driver.ExecerContext).ExecContext(ctx, query, args)
+}
+
+// CheckNamedValue lets pgx convert the arguments itself, so slices still work for any($1)
+func (c countingConn) CheckNamedValue(nv *driver.NamedValue) error {
+	return c.Conn.(driver.NamedValueChecker).CheckNamedValue(nv)
+}
+
+// BenchmarkBlog_GetAllPaginated lists pages of blogs of different sizes and fails when
+// the number of queries per listing is not the same for all of them
+func BenchmarkBlog_GetAllPaginated(b *testing.B) {
+	countingDB, err := sql.Open("pgx-counting", fmt.Sprintf(dsn, host, port, user, password, dbName))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer countingDB.Close()
+
+	// 500 published blogs with two categorys each
+	_, err = testDB.Exec(`insert into blogs (title, slug, createdby_id, content, status, created_at, updated_at)
+		select 'Bench ' || i, 'bench-' || i, 1, 'content', 'published', now(), now() from generate_series(1, 500) i`)
+	if err != nil {
+		b.Fatal(err)
+	}
+	_, err = testDB.Exec(`insert into blogs_categorys (blog_id, category_id, created_at, updated_at)
+		select b.id, c.id, now(), now() from blogs b, categorys c
+		where b.slug like 'bench-%' and c.slug in ('horror', 'classic')`)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer func() {
+		_, _ = testDB.Exec(`delete from blogs_categorys where blog_id in (select id from blogs where slug like 'bench-%')`)
+		_, _ = testDB.Exec(`delete from blogs where slug like 'bench-%'`)
+	}()
+
+	// send the models through the counting driver while benchmarking
+	db = countingDB
+	defer func() { db = testDB }()
+
+	for _, size := range []int{1, 50, 500} {
+		b.Run(fmt.Sprintf("%d blogs", size), func(b *testing.B) {
+			filters := BlogFilters{Pagination: Pagination{Page: 1, PageSize: size}, PublishedOnly: true}
+			atomic.StoreInt64(&queryCount, 0)
+
+			for i := 0; i < b.N; i++ {
+				blogs, _, err := models.Blog.GetAllPaginated(filters)
+				if err != nil {
+					b.Fatal(err)
+				}
+
+				if len(blogs) != size {
+					b.Fatalf("expected %d blogs but got %d", size, len(blogs))
+				}
+			}
+
+			queries := float64(atomic.LoadInt64(&queryCount)) / float64(b.N)
+			b.ReportMetric(queries, "queries/op")
+
+			// one query for the blogs and one for all of their categorys
+			if queries != 2 {
+				b.Errorf("expected 2 queries per listing of %d blogs but got %.1f", size, queries)
+			}
+		})
+	}
+}
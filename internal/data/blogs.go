@@ -3,12 +3,45 @@ package data
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"time"
 
 	"github.com/mozillazg/go-slugify"
 )
 
+// ErrNotBlogOwner is returned by the owner scoped blog methods when the blog belongs to someone else
+var ErrNotBlogOwner = errors.New("you can only manage your own blogs")
+
+// ErrInvalidStatusTransition is returned when a blog can't be moved from its current status to the one asked for
+var ErrInvalidStatusTransition = errors.New("invalid blog status transition")
+
+// The publication states of a blog, only published blogs are visible on the public routes.
+// A scheduled blog gets published by the scheduler once its publish_at time arrives
+const (
+	BlogStatusDraft     = "draft"
+	BlogStatusInReview  = "in_review"
+	BlogStatusScheduled = "scheduled"
+	BlogStatusPublished = "published"
+	BlogStatusArchived  = "archived"
+)
+
+// blogTransitions lists the states a blog can move to from each state
+var blogTransitions = map[string][]string{
+	BlogStatusDraft:     {BlogStatusInReview, BlogStatusScheduled, BlogStatusPublished, BlogStatusArchived},
+	BlogStatusInReview:  {BlogStatusDraft, BlogStatusScheduled, BlogStatusPublished, BlogStatusArchived},
+	BlogStatusScheduled: {BlogStatusDraft, BlogStatusPublished, BlogStatusArchived},
+	BlogStatusPublished: {BlogStatusDraft, BlogStatusArchived},
+	BlogStatusArchived:  {BlogStatusDraft},
+}
+
+// authorTransitions is the part of blogTransitions an author can do without an editor, sending a draft for review
+// and taking it back. Everything that touches a published or scheduled blog is up to editors and admins
+var authorTransitions = map[string][]string{
+	BlogStatusDraft:    {BlogStatusInReview},
+	BlogStatusInReview: {BlogStatusDraft},
+}
+
 // Blog is the definition of a single blog
 type Blog struct {
 	ID          int        `json:"id"`
@@ -19,34 +52,42 @@ type Blog struct {
 	Description string     `json:"description"`
 	Content     string     `json:"content"`
 	Categorys   []Category `json:"category"`
+	Status      string     `json:"status"`
+	PublishAt   *time.Time `json:"publish_at,omitempty"`
+	PublishedAt *time.Time `json:"published_at,omitempty"`
 	CreatedAt   time.Time  `json:"created_at"`
 	UpdatedAt   time.Time  `json:"updated_at"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty"`
 	CategoryIDs []int      `json:"category_ids,omitempty"`
+	UpdatedByID int        `json:"-"`
 }
 
-// Category is the definition of a single category type
-type Category struct {
-	ID           int       `json:"id"`
-	CategoryName string    `json:"category_name"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+// GetAll returns a slice of all blogs, whatever their status, except the ones in the trash
+func (b *Blog) GetAll() ([]*Blog, error) {
+	return getAllBlogs(0)
 }
 
-// GetAll returns a slice of all blogs
-func (b *Blog) GetAll() ([]*Blog, error) {
+// GetAllForOwner is GetAll for the blogs of one author only
+func (b *Blog) GetAllForOwner(ownerID int) ([]*Blog, error) {
+	return getAllBlogs(ownerID)
+}
+
+// getAllBlogs returns the blogs of ownerID, or of everybody when ownerID is 0
+func getAllBlogs(ownerID int) ([]*Blog, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	// (select array_to_string(array_agg(category_id), ',') from blogs_categorys where blog_id = b.id)
-	query := `SELECT b.id, b.title, b.slug, b.createdby_id,  b.description, b.content, b.created_at, b.updated_at, 
+	query := `SELECT b.id, b.title, b.slug, b.createdby_id,  b.description, b.content, b.status, b.publish_at, b.published_at, b.created_at, b.updated_at, 
             u.id, u.first_name
             from blogs b
             left join users u on (b.createdby_id = u.id)
+            where b.deleted_at is null and ($1 = 0 or b.createdby_id = $1)
             order by b.title`
 
 	var blogs []*Blog
 
-	rows, err := db.QueryContext(ctx, query)
+	rows, err := db.QueryContext(ctx, query, ownerID)
 	if err != nil {
 		return nil, err
 	}
@@ -61,6 +102,9 @@ func (b *Blog) GetAll() ([]*Blog, error) {
 			&blog.CreatedByID,
 			&blog.Description,
 			&blog.Content,
+			&blog.Status,
+			&blog.PublishAt,
+			&blog.PublishedAt,
 			&blog.CreatedAt,
 			&blog.UpdatedAt,
 			&blog.CreatedBy.ID,        //User ID
@@ -70,76 +114,100 @@ func (b *Blog) GetAll() ([]*Blog, error) {
 			return nil, err
 		}
 
-		// get categorys
-		categorys, ids, err := b.categorysForBlog(blog.ID)
-		if err != nil {
-			return nil, err
-		}
+		blogs = append(blogs, &blog)
+	}
 
-		blog.Categorys = categorys
-		blog.CategoryIDs = ids
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
 
-		blogs = append(blogs, &blog)
+	// get categorys for all the blogs in one go
+	err = attachCategorys(ctx, blogs)
+	if err != nil {
+		return nil, err
 	}
 
 	return blogs, nil
 }
 
-// GetAllPaginated returns a slice of all blogs but paginated
-func (b *Blog) GetAllPaginated(page, pageSize int) ([]*Blog, error) {
+// blogFiltersWhere is the where clause for the BlogFilters, it takes
+// PublishedOnly, CategorySlug, AuthorID, From and To as $1 to $5. Blogs in the trash never match
+const blogFiltersWhere = `b.deleted_at is null
+            and ($1 = false or b.status = 'published')
+            and ($2 = '' or b.id in (select bc.blog_id from blogs_categorys bc
+                inner join categorys c on (c.id = bc.category_id)
+                where c.slug = $2))
+            and ($3 = 0 or b.createdby_id = $3)
+            and ($4::timestamp is null or b.created_at >= $4::timestamp)
+            and ($5::timestamp is null or b.created_at < $5::timestamp)`
+
+// GetAllPaginated returns one page of the blogs matching filters, together with the pagination metadata
+func (b *Blog) GetAllPaginated(filters BlogFilters) ([]*Blog, Metadata, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	limit := pageSize
-	offset := (page - 1) * pageSize
-
-	// (select array_to_string(array_agg(category_id), ',') from blogs_categorys where blog_id = b.id)
-	query := `select b.id, b.title, b.slug, b.createdby_id, b.created_by,  b.description, b.content, b.created_at, b.updated_at, 
-            u.id, u.first_name, 
+	// the sort column and direction come from a safelist, so it is fine to put them straight in the query
+	query := fmt.Sprintf(`select count(*) over(), b.id, b.title, b.slug, b.createdby_id, b.description, b.content, b.status, b.publish_at, b.published_at, b.created_at, b.updated_at,
+            u.id, u.first_name
             from blogs b
             left join users u on (b.createdby_id = u.id)
-            order by b.title
-			limit $1 offset $2`
-
-	var blogs []*Blog
-
-	rows, err := db.QueryContext(ctx, query, limit, offset)
+            where `+blogFiltersWhere+`
+            order by b.%s %s nulls last, b.id %s
+            limit $6 offset $7`, filters.sortColumn(), filters.sortDirection(), filters.sortDirection())
+
+	rows, err := db.QueryContext(ctx, query,
+		filters.PublishedOnly,
+		filters.CategorySlug,
+		filters.AuthorID,
+		filters.fromUTC(),
+		filters.toUTC(),
+		filters.limit(),
+		filters.offset(),
+	)
 	if err != nil {
-		return nil, err
+		return nil, Metadata{}, err
 	}
 	defer rows.Close()
 
+	totalRecords := 0
+	blogs := []*Blog{}
+
 	for rows.Next() {
 		var blog Blog
 		err := rows.Scan(
+			&totalRecords,
 			&blog.ID,
 			&blog.Title,
 			&blog.Slug,
 			&blog.CreatedByID,
-			&blog.CreatedBy,
 			&blog.Description,
 			&blog.Content,
+			&blog.Status,
+			&blog.PublishAt,
+			&blog.PublishedAt,
 			&blog.CreatedAt,
 			&blog.UpdatedAt,
 			&blog.CreatedBy.ID,
 			&blog.CreatedBy.FirstName,
 		)
 		if err != nil {
-			return nil, err
-		}
-
-		// get categorys
-		categorys, ids, err := b.categorysForBlog(blog.ID)
-		if err != nil {
-			return nil, err
+			return nil, Metadata{}, err
 		}
-		blog.Categorys = categorys
-		blog.CategoryIDs = ids
 
 		blogs = append(blogs, &blog)
 	}
 
-	return blogs, nil
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// get categorys for all the blogs in one go
+	err = attachCategorys(ctx, blogs)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return blogs, calculateMetadata(totalRecords, filters.Pagination), nil
 }
 
 // GetOneById returns one blog by its id
@@ -147,11 +215,11 @@ func (b *Blog) GetOneById(id int) (*Blog, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select b.id, b.title, b.slug, b.createdby_id, b.created_by,  b.description, b.content, b.created_at, b.updated_at,
-            u.id, u.first_name,
+	query := `select b.id, b.title, b.slug, b.createdby_id, b.description, b.content, b.status, b.publish_at, b.published_at, b.created_at, b.updated_at,
+            u.id, u.first_name
             from blogs b
             left join users u on (b.createdby_id = u.id)
-            where b.id = $1`
+            where b.id = $1 and b.deleted_at is null`
 
 	row := db.QueryRowContext(ctx, query, id)
 
@@ -162,9 +230,11 @@ func (b *Blog) GetOneById(id int) (*Blog, error) {
 		&blog.Title,
 		&blog.Slug,
 		&blog.CreatedByID,
-		&blog.CreatedBy,
 		&blog.Description,
 		&blog.Content,
+		&blog.Status,
+		&blog.PublishAt,
+		&blog.PublishedAt,
 		&blog.CreatedAt,
 		&blog.UpdatedAt,
 		&blog.CreatedBy.ID,
@@ -185,18 +255,27 @@ func (b *Blog) GetOneById(id int) (*Blog, error) {
 	return &blog, nil
 }
 
-// GetOneBySlug returns one blog by slug
+// GetOneBySlug returns one blog by slug, whatever its status
 func (b *Blog) GetOneBySlug(slug string) (*Blog, error) {
+	return b.getOneBySlug(slug, false)
+}
+
+// GetPublishedBySlug returns one blog by slug, but only if it is published
+func (b *Blog) GetPublishedBySlug(slug string) (*Blog, error) {
+	return b.getOneBySlug(slug, true)
+}
+
+func (b *Blog) getOneBySlug(slug string, publishedOnly bool) (*Blog, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `SELECT b.id, b.title, b.slug, b.createdby_id, b.description, b.content, b.created_at, b.updated_at, 
+	query := `SELECT b.id, b.title, b.slug, b.createdby_id, b.description, b.content, b.status, b.publish_at, b.published_at, b.created_at, b.updated_at, 
 			u.id, u.first_name
 			from blogs b
 			left join users u on (b.createdby_id = u.id)
-			where b.slug = $1`
+			where b.slug = $1 and b.deleted_at is null and ($2 = false or b.status = 'published')`
 
-	row := db.QueryRowContext(ctx, query, slug)
+	row := db.QueryRowContext(ctx, query, slug, publishedOnly)
 
 	var blog Blog
 
@@ -207,6 +286,9 @@ func (b *Blog) GetOneBySlug(slug string) (*Blog, error) {
 		&blog.CreatedByID,
 		&blog.Description,
 		&blog.Content,
+		&blog.Status,
+		&blog.PublishAt,
+		&blog.PublishedAt,
 		&blog.CreatedAt,
 		&blog.UpdatedAt,
 		&blog.CreatedBy.ID,
@@ -235,7 +317,7 @@ func (b *Blog) categorysForBlog(id int) ([]Category, []int, error) {
 	// get genres
 	var categorys []Category
 	var categoryIDs []int
-	categoryQuery := `SELECT id, category_name, created_at, updated_at from categorys where id in (SELECT category_id 
+	categoryQuery := `SELECT id, category_name, slug, created_at, updated_at from categorys where id in (SELECT category_id 
                 from blogs_categorys where blog_id = $1) order by category_name`
 
 	cRows, err := db.QueryContext(ctx, categoryQuery, id)
@@ -250,6 +332,7 @@ func (b *Blog) categorysForBlog(id int) ([]Category, []int, error) {
 		err = cRows.Scan(
 			&category.ID,
 			&category.CategoryName,
+			&category.Slug,
 			&category.CreatedAt,
 			&category.UpdatedAt)
 		if err != nil {
@@ -262,149 +345,733 @@ func (b *Blog) categorysForBlog(id int) ([]Category, []int, error) {
 	return categorys, categoryIDs, nil
 }
 
-// Create saves one blog to the database
+// attachCategorys fills in the categorys of every blog in blogs with a single query,
+// so listing blogs costs the same number of queries whatever the number of blogs
+func attachCategorys(ctx context.Context, blogs []*Blog) error {
+	if len(blogs) == 0 {
+		return nil
+	}
+
+	ids := make([]int, len(blogs))
+	for i, blog := range blogs {
+		ids[i] = blog.ID
+	}
+
+	query := `select bc.blog_id, c.id, c.category_name, c.slug, c.created_at, c.updated_at
+		from blogs_categorys bc
+		inner join categorys c on (c.id = bc.category_id)
+		where bc.blog_id = any($1)
+		order by c.category_name`
+
+	rows, err := db.QueryContext(ctx, query, ids)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	categorys := make(map[int][]Category, len(blogs))
+
+	for rows.Next() {
+		var blogID int
+		var category Category
+		err := rows.Scan(
+			&blogID,
+			&category.ID,
+			&category.CategoryName,
+			&category.Slug,
+			&category.CreatedAt,
+			&category.UpdatedAt,
+		)
+		if err != nil {
+			return err
+		}
+
+		categorys[blogID] = append(categorys[blogID], category)
+	}
+
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, blog := range blogs {
+		blog.Categorys = categorys[blog.ID]
+		blog.CategoryIDs = nil
+		for _, category := range blog.Categorys {
+			blog.CategoryIDs = append(blog.CategoryIDs, category.ID)
+		}
+	}
+
+	return nil
+}
+
+// Create saves one blog to the database, together with its first revision and its categorys.
+// everything is written in one transaction, so either all of it is saved or none of it is
 func (b *Blog) Create(blog Blog) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `insert into blogs (title, slug, createdby_id, created_by, description, content, created_at, updated_at)
+	// every new blog starts out as a draft
+	stmt := `insert into blogs (title, slug, createdby_id, description, content, status, created_at, updated_at)
             values ($1, $2, $3, $4, $5, $6, $7, $8) returning id`
 
 	var newID int
-	err := db.QueryRowContext(ctx, stmt,
-		blog.Title,
-		blog.CreatedByID,
-		blog.CreatedBy,
-		slugify.Slugify(b.Title),
-		blog.Description,
-		blog.Content,
-		time.Now(),
-		time.Now(),
-	).Scan(&newID)
-	if err != nil {
-		return 0, err
-	}
+	err := withTransaction(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, stmt,
+			blog.Title,
+			slugify.Slugify(blog.Title),
+			blog.CreatedByID,
+			blog.Description,
+			blog.Content,
+			BlogStatusDraft,
+			time.Now(),
+			time.Now(),
+		).Scan(&newID)
+		if err != nil {
+			return err
+		}
 
-	// update categories using caegory ids
-	if len(blog.CategoryIDs) > 0 {
-		stmt = `DELETE from blogs_categorys WHERE blog_id = $1`
-		_, err := db.ExecContext(ctx, stmt, blog.ID)
+		// the first revision is the blog as it was created
+		blog.ID = newID
+		err = insertRevision(ctx, tx, blog, blog.CreatedByID)
 		if err != nil {
-			return newID, fmt.Errorf("blog updated, but categorys not: %s", err.Error())
+			return err
 		}
 
-		for _, x := range blog.CategoryIDs {
-			stmt = `insert into blogs_categorys (blog_id, category_id, created_at, updated_at)
-			values ($1, $2, $3, $4)`
-			_, err = db.ExecContext(ctx, stmt, newID, x, time.Now(), time.Now())
-			if err != nil {
-				return newID, fmt.Errorf("blog updated, but categorys not: %s", err.Error())
-			}
+		err = updateSearchIndex(ctx, tx, newID)
+		if err != nil {
+			return err
 		}
+
+		return blog.updateCategorys(ctx, tx)
+	})
+	if err != nil {
+		return 0, err
 	}
 
 	return newID, nil
 }
 
-// Update updates one blog in the database
+// Update updates one blog in the database and writes a new revision of it, in one transaction
+// the author of a blog never changes, so createdby_id is left alone
 func (b *Blog) Update() error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
 	stmt := `update blogs set
         title = $1,
-        createdby_id = $2,
-        slug = $3,
-        description = $4,
-		content = $5,
-        updated_at = $6
-        where id = $7`
-
-	_, err := db.ExecContext(ctx, stmt,
-		b.Title,
-		b.CreatedByID,
-		slugify.Slugify(b.Title),
-		b.Description,
-		b.Content,
-		time.Now(),
-		b.ID)
+        slug = $2,
+        description = $3,
+		content = $4,
+        updated_at = $5
+        where id = $6 and deleted_at is null`
+
+	// whoever saved the blog is the author of the revision, when we don't know that it is the blog author
+	authorID := b.UpdatedByID
+	if authorID == 0 {
+		authorID = b.CreatedByID
+	}
+
+	return withTransaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, stmt,
+			b.Title,
+			slugify.Slugify(b.Title),
+			b.Description,
+			b.Content,
+			time.Now(),
+			b.ID)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+
+		// the blog is gone or in the trash
+		if rowsAffected == 0 {
+			return sql.ErrNoRows
+		}
+
+		return b.saveRelated(ctx, tx, authorID)
+	})
+}
+
+// UpdateByOwner updates one blog in the database and writes a new revision of it, but only if it was created by ownerID.
+// It returns ErrNotBlogOwner when the blog belongs to someone else and sql.ErrNoRows when it does not exist
+func (b *Blog) UpdateByOwner(ownerID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update blogs set
+        title = $1,
+        slug = $2,
+        description = $3,
+		content = $4,
+        updated_at = $5
+        where id = $6 and createdby_id = $7 and deleted_at is null`
+
+	return withTransaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, stmt,
+			b.Title,
+			slugify.Slugify(b.Title),
+			b.Description,
+			b.Content,
+			time.Now(),
+			b.ID,
+			ownerID)
+		if err != nil {
+			return err
+		}
+
+		err = ownerCheck(ctx, tx, result, b.ID)
+		if err != nil {
+			return err
+		}
+
+		return b.saveRelated(ctx, tx, ownerID)
+	})
+}
+
+// saveRelated writes everything that has to change together with an updated blog row:
+// a new revision by authorID, the search index and the categorys
+func (b *Blog) saveRelated(ctx context.Context, tx *sql.Tx, authorID int) error {
+	err := insertRevision(ctx, tx, *b, authorID)
+	if err != nil {
+		return err
+	}
+
+	err = updateSearchIndex(ctx, tx, b.ID)
+	if err != nil {
+		return err
+	}
+
+	return b.updateCategorys(ctx, tx)
+}
+
+// updateCategorys replaces the categorys of the blog with the ones in b.CategoryIDs,
+// when no category ids are given the categorys the blog already has are left alone
+func (b *Blog) updateCategorys(ctx context.Context, q dbtx) error {
+	if len(b.CategoryIDs) == 0 {
+		return nil
+	}
+
+	// delete existing categorys
+	_, err := q.ExecContext(ctx, `delete from blogs_categorys where blog_id = $1`, b.ID)
 	if err != nil {
 		return err
 	}
 
-	// update categorys
-	if len(b.Categorys) > 0 {
-		// delete existing category
-		stmt = `delete from categorys where blog_id = $1`
-		_, err := db.ExecContext(ctx, stmt, b.ID)
+	// add new categorys
+	stmt := `insert into blogs_categorys (blog_id, category_id, created_at, updated_at)
+		values ($1, $2, $3, $4)`
+	for _, id := range b.CategoryIDs {
+		_, err = q.ExecContext(ctx, stmt, b.ID, id, time.Now(), time.Now())
 		if err != nil {
-			return fmt.Errorf("blog updated, but categorys not: %s", err.Error())
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ValidBlogStatus reports whether status is one of the blog states we know about
+func ValidBlogStatus(status string) bool {
+	_, ok := blogTransitions[status]
+	return ok
+}
+
+// CanTransitionTo reports whether the blog is allowed to move from its current status to status
+func (b *Blog) CanTransitionTo(status string) bool {
+	return canTransition(blogTransitions, b.Status, status)
+}
+
+// AuthorCanTransitionTo reports whether an author, rather than an editor, may move the blog to status
+func (b *Blog) AuthorCanTransitionTo(status string) bool {
+	return canTransition(authorTransitions, b.Status, status)
+}
+
+// canTransition looks up whether transitions allows moving from one status to another
+func canTransition(transitions map[string][]string, from, to string) bool {
+	for _, next := range transitions[from] {
+		if next == to {
+			return true
 		}
+	}
+	return false
+}
+
+// SetStatus moves the blog to a new status. publishAt is required when scheduling and ignored otherwise.
+// The update only goes through if nobody changed the status in the meantime
+func (b *Blog) SetStatus(status string, publishAt *time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	if !b.CanTransitionTo(status) {
+		return ErrInvalidStatusTransition
+	}
 
-		// add new categorys
-		for _, x := range b.Categorys {
-			stmt = `insert into blogs_categorys (blog_id, category_id, created_at, updated_at)
-                values ($1, $2, $3, $4)`
-			_, err = db.ExecContext(ctx, stmt, b.ID, x.ID, time.Now(), time.Now())
-			if err != nil {
-				return fmt.Errorf("blog updated, but categorys not: %s", err.Error())
-			}
+	var publishedAt *time.Time
+	switch status {
+	case BlogStatusScheduled:
+		if publishAt == nil || publishAt.Before(time.Now()) {
+			return errors.New("a scheduled blog needs a publish_at time in the future")
 		}
+	case BlogStatusPublished:
+		now := time.Now()
+		publishedAt = &now
+		publishAt = nil
+	default:
+		publishAt = nil
+	}
+
+	// keep the original publish date when archiving
+	stmt := `update blogs set
+		status = $1,
+		publish_at = $2,
+		published_at = case when $1 = 'archived' then published_at else $3 end,
+		updated_at = $4
+		where id = $5 and status = $6 and deleted_at is null`
+
+	result, err := db.ExecContext(ctx, stmt, status, publishAt, publishedAt, time.Now(), b.ID, b.Status)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrInvalidStatusTransition
+	}
+
+	b.Status = status
+	b.PublishAt = publishAt
+	if status != BlogStatusArchived {
+		b.PublishedAt = publishedAt
 	}
 
 	return nil
 }
 
-// DeleteByID deletes a blog by id
+// PublishScheduled publishes every scheduled blog whose publish_at time has arrived
+// and returns how many blogs were published
+func (b *Blog) PublishScheduled() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update blogs set
+		status = 'published',
+		published_at = publish_at,
+		publish_at = null,
+		updated_at = $1
+		where status = 'scheduled' and publish_at <= $1 and deleted_at is null`
+
+	result, err := db.ExecContext(ctx, stmt, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// DeleteByID moves a blog to the trash, it stays there until it is restored or purged
 func (b *Blog) DeleteByID(id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `delete from blogs where id = $1`
-	_, err := db.ExecContext(ctx, stmt, id)
+	stmt := `update blogs set deleted_at = $1 where id = $2 and deleted_at is null`
+	result, err := db.ExecContext(ctx, stmt, time.Now(), id)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	return expectRows(result)
 }
 
-// Get blogs by the creator Id
-func (u *User) FindBlogByUserId(id int) ([]*Blog, error) {
+// DeleteByIDForOwner moves a blog to the trash, but only if it was created by ownerID.
+// It returns ErrNotBlogOwner when the blog belongs to someone else and sql.ErrNoRows when it does not exist
+func (b *Blog) DeleteByIDForOwner(id, ownerID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `SELECT title, slug, description, content, created_at, updated_at FROM blog WHERE id = ? AND author = ?, id, user.ID`
+	stmt := `update blogs set deleted_at = $1 where id = $2 and createdby_id = $3 and deleted_at is null`
+	result, err := db.ExecContext(ctx, stmt, time.Now(), id, ownerID)
+	if err != nil {
+		return err
+	}
+
+	return ownerCheck(ctx, db, result, id)
+}
 
-	// Query every row for blogs and close when  done
-	rows, err := db.QueryContext(ctx, query, id)
+// GetTrashed returns every blog in the trash, the most recently deleted first
+func (b *Blog) GetTrashed() ([]*Blog, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select b.id, b.title, b.slug, b.createdby_id, b.status, b.created_at, b.updated_at, b.deleted_at,
+		u.id, u.first_name
+		from blogs b
+		left join users u on (b.createdby_id = u.id)
+		where b.deleted_at is not null
+		order by b.deleted_at desc`
+
+	rows, err := db.QueryContext(ctx, query)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
-	// varibale user points at an slice of User. we will be returning every field in that struct
 	var blogs []*Blog
 
-	// for loop to each individual field in our User slice one by one so they can be individually scanned for errors before we return any of them
 	for rows.Next() {
 		var blog Blog
 		err := rows.Scan(
+			&blog.ID,
+			&blog.Title,
+			&blog.Slug,
+			&blog.CreatedByID,
+			&blog.Status,
+			&blog.CreatedAt,
+			&blog.UpdatedAt,
+			&blog.DeletedAt,
+			&blog.CreatedBy.ID,
+			&blog.CreatedBy.FirstName,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		blogs = append(blogs, &blog)
+	}
+
+	return blogs, rows.Err()
+}
+
+// Restore takes a blog out of the trash, with the status it had when it was deleted
+func (b *Blog) Restore(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, `update blogs set deleted_at = null where id = $1 and deleted_at is not null`, id)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
+
+// Purge permanently deletes a blog that is in the trash, its categorys and revisions go with it
+func (b *Blog) Purge(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, `delete from blogs where id = $1 and deleted_at is not null`, id)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
+
+// PurgeTrashed permanently deletes every blog that was put in the trash before before,
+// and returns how many blogs were deleted
+func (b *Blog) PurgeTrashed(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, `delete from blogs where deleted_at < $1`, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// ownerCheck works out why an owner scoped statement did not touch any rows,
+// either the blog does not exist or it belongs to someone else
+func ownerCheck(ctx context.Context, q dbtx, result sql.Result, id int) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	var exists bool
+	err = q.QueryRowContext(ctx, `select exists(select 1 from blogs where id = $1 and deleted_at is null)`, id).Scan(&exists)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	return ErrNotBlogOwner
+}
+
+// GetFeed returns up to limit blogs matching filters, newest first, starting from cursor.
+// Unlike GetAllPaginated this does not use an offset, so it stays fast on a big blogs table and
+// does not skip or repeat blogs when new ones get published while someone is paging.
+// The public feed of published blogs is in the order they were published, everything else in the order
+// they were created. The sort and pagination fields of filters are ignored, a nil cursor gets the first page
+func (b *Blog) GetFeed(filters BlogFilters, cursor *Cursor, limit int) ([]*Blog, CursorPage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// going backwards we read the blogs just newer than the cursor oldest first, and flip them around after
+	backwards := cursor != nil && cursor.Backwards
+	comparison, direction := "<", "desc"
+	if backwards {
+		comparison, direction = ">", "asc"
+	}
+
+	// a blog gets its published_at when it is published, so every blog in the public feed has one.
+	// blogs.created_at is a timestamp without time zone, which we read back as UTC. The cursor is compared as
+	// the type of the column and in UTC, so the comparison is of the exact values we handed out, whatever
+	// the time zone of the server or the session
+	column, columnType := "b.created_at", "timestamp"
+	cursorTime := func(blog *Blog) time.Time { return blog.CreatedAt }
+	if filters.PublishedOnly {
+		column, columnType = "b.published_at", "timestamptz"
+		cursorTime = func(blog *Blog) time.Time { return *blog.PublishedAt }
+	}
+
+	var cursorAt *time.Time
+	var cursorID int
+	if cursor != nil {
+		cursorAt = inUTC(&cursor.Time)
+		cursorID = cursor.ID
+	}
+
+	// we ask for one blog more than we need to know if there is another page after this one
+	query := fmt.Sprintf(`select b.id, b.title, b.slug, b.createdby_id, b.description, b.content, b.status, b.publish_at, b.published_at, b.created_at, b.updated_at,
+            u.id, u.first_name
+            from blogs b
+            left join users u on (b.createdby_id = u.id)
+            where `+blogFiltersWhere+`
+            and ($6::%[1]s is null or (%[2]s, b.id) %[3]s ($6::%[1]s, $7))
+            order by %[2]s %[4]s, b.id %[4]s
+            limit $8`, columnType, column, comparison, direction)
+
+	rows, err := db.QueryContext(ctx, query,
+		filters.PublishedOnly,
+		filters.CategorySlug,
+		filters.AuthorID,
+		filters.fromUTC(),
+		filters.toUTC(),
+		cursorAt,
+		cursorID,
+		limit+1,
+	)
+	if err != nil {
+		return nil, CursorPage{}, err
+	}
+	defer rows.Close()
+
+	blogs := []*Blog{}
+
+	for rows.Next() {
+		var blog Blog
+		err := rows.Scan(
+			&blog.ID,
 			&blog.Title,
 			&blog.Slug,
 			&blog.CreatedByID,
-			&blog.CreatedBy,
 			&blog.Description,
 			&blog.Content,
+			&blog.Status,
+			&blog.PublishAt,
+			&blog.PublishedAt,
 			&blog.CreatedAt,
 			&blog.UpdatedAt,
+			&blog.CreatedBy.ID,
+			&blog.CreatedBy.FirstName,
 		)
 		if err != nil {
-			return nil, err
+			return nil, CursorPage{}, err
 		}
 
-		// if no errors are found each field will be appended back to the slice blog
 		blogs = append(blogs, &blog)
 	}
-	return blogs, nil
 
+	if err = rows.Err(); err != nil {
+		return nil, CursorPage{}, err
+	}
+
+	// get categorys for all the blogs in one go
+	err = attachCategorys(ctx, blogs)
+	if err != nil {
+		return nil, CursorPage{}, err
+	}
+
+	hasMore := len(blogs) > limit
+	if hasMore {
+		blogs = blogs[:limit]
+	}
+
+	if backwards {
+		for i, j := 0, len(blogs)-1; i < j; i, j = i+1, j-1 {
+			blogs[i], blogs[j] = blogs[j], blogs[i]
+		}
+	}
+
+	var page CursorPage
+	if len(blogs) == 0 {
+		return blogs, page, nil
+	}
+
+	first, last := blogs[0], blogs[len(blogs)-1]
+
+	// there are older blogs when we found more going forwards, or when we came back from an older page
+	if hasMore || backwards {
+		page.NextCursor = Cursor{Time: cursorTime(last).UTC(), ID: last.ID}.Encode()
+	}
+
+	// there are newer blogs when we found more going backwards, or when we came from a newer page
+	if (backwards && hasMore) || (!backwards && cursor != nil) {
+		page.PrevCursor = Cursor{Time: cursorTime(first).UTC(), ID: first.ID, Backwards: true}.Encode()
+	}
+
+	return blogs, page, nil
+}
+
+// updateSearchIndex rebuilds the full text search vector of a blog from its title, description and content,
+// a match in the title weighs more than one in the description, which weighs more than one in the content
+func updateSearchIndex(ctx context.Context, q dbtx, id int) error {
+	stmt := `update blogs set search_vector =
+		setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(description, '')), 'B') ||
+		setweight(to_tsvector('english', coalesce(content, '')), 'C')
+		where id = $1`
+
+	_, err := q.ExecContext(ctx, stmt, id)
+	if err != nil {
+		return fmt.Errorf("updating search index: %s", err.Error())
+	}
+
+	return nil
+}
+
+// BlogSearchResult is a blog found by Search, with how well it matched and a snippet of the content
+// with the matching words wrapped in <mark> tags
+type BlogSearchResult struct {
+	Blog
+	Rank    float32 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// Search returns a page of the blogs matching the full text search query, best match first.
+// The query can use the web search syntax of postgres, like "quoted phrases", or and -excluded words.
+// The sort fields of filters are ignored
+func (b *Blog) Search(search string, filters BlogFilters) ([]*BlogSearchResult, Metadata, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// the page is worked out first so we only build the snippets for the blogs on it,
+	// html tags are taken out of the content before building the snippets so we don't cut one in half
+	query := `select r.total, b.id, b.title, b.slug, b.createdby_id, b.description, b.content, b.status, b.publish_at, b.published_at, b.created_at, b.updated_at,
+            u.id, u.first_name, r.rank,
+            ts_headline('english', regexp_replace(coalesce(b.content, ''), '<[^>]*>', ' ', 'g'), r.query,
+                'StartSel=<mark>, StopSel=</mark>, MaxFragments=2, MaxWords=30, MinWords=10')
+            from (
+                select count(*) over() as total, b.id, ts_rank(b.search_vector, q) as rank, q as query
+                from blogs b, websearch_to_tsquery('english', $6) q
+                where ` + blogFiltersWhere + `
+                and b.search_vector @@ q
+                order by rank desc, b.id desc
+                limit $7 offset $8
+            ) r
+            inner join blogs b on (b.id = r.id)
+            left join users u on (b.createdby_id = u.id)
+            order by r.rank desc, b.id desc`
+
+	rows, err := db.QueryContext(ctx, query,
+		filters.PublishedOnly,
+		filters.CategorySlug,
+		filters.AuthorID,
+		filters.fromUTC(),
+		filters.toUTC(),
+		search,
+		filters.limit(),
+		filters.offset(),
+	)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	results := []*BlogSearchResult{}
+
+	for rows.Next() {
+		var result BlogSearchResult
+		err := rows.Scan(
+			&totalRecords,
+			&result.ID,
+			&result.Title,
+			&result.Slug,
+			&result.CreatedByID,
+			&result.Description,
+			&result.Content,
+			&result.Status,
+			&result.PublishAt,
+			&result.PublishedAt,
+			&result.CreatedAt,
+			&result.UpdatedAt,
+			&result.CreatedBy.ID,
+			&result.CreatedBy.FirstName,
+			&result.Rank,
+			&result.Snippet,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		results = append(results, &result)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	// get categorys for all the results in one go
+	blogs := make([]*Blog, len(results))
+	for i, result := range results {
+		blogs[i] = &result.Blog
+	}
+
+	err = attachCategorys(ctx, blogs)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return results, calculateMetadata(totalRecords, filters.Pagination), nil
+}
+
+// GetPublishedByCategory returns a page of the published blogs in the category with the given slug, newest first
+func (b *Blog) GetPublishedByCategory(slug string, p Pagination) ([]*Blog, Metadata, error) {
+	return b.GetAllPaginated(BlogFilters{
+		Pagination:    p,
+		Sort:          "published_at",
+		Order:         "desc",
+		CategorySlug:  slug,
+		PublishedOnly: true,
+	})
+}
+
+// GetPublishedByAuthor returns a page of the published blogs created by authorID, newest first
+func (b *Blog) GetPublishedByAuthor(authorID int, p Pagination) ([]*Blog, Metadata, error) {
+	return b.GetAllPaginated(BlogFilters{
+		Pagination:    p,
+		Sort:          "published_at",
+		Order:         "desc",
+		AuthorID:      authorID,
+		PublishedOnly: true,
+	})
 }
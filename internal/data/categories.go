@@ -0,0 +1,195 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/mozillazg/go-slugify"
+)
+
+// ErrCategoryInUse is returned when deleting a category that still has blogs, without asking for a cascade
+var ErrCategoryInUse = errors.New("category is still used by one or more blogs")
+
+// Category is the definition of a single category type
+type Category struct {
+	ID           int       `json:"id"`
+	CategoryName string    `json:"category_name"`
+	Slug         string    `json:"slug"`
+	PostCount    int       `json:"post_count"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+// GetAll returns all categorys sorted by name, with the number of published blogs in each of them
+func (c *Category) GetAll() ([]*Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select c.id, c.category_name, c.slug, c.created_at, c.updated_at,
+		(select count(bc.id) from blogs_categorys bc
+			inner join blogs b on (b.id = bc.blog_id)
+			where bc.category_id = c.id and b.status = 'published' and b.deleted_at is null) as post_count
+		from categorys c
+		order by c.category_name`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var categorys []*Category
+
+	for rows.Next() {
+		var category Category
+		err := rows.Scan(
+			&category.ID,
+			&category.CategoryName,
+			&category.Slug,
+			&category.CreatedAt,
+			&category.UpdatedAt,
+			&category.PostCount,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		categorys = append(categorys, &category)
+	}
+
+	return categorys, nil
+}
+
+// GetByID returns one category by its id
+func (c *Category) GetByID(id int) (*Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, category_name, slug, created_at, updated_at from categorys where id = $1`
+
+	var category Category
+	err := db.QueryRowContext(ctx, query, id).Scan(
+		&category.ID,
+		&category.CategoryName,
+		&category.Slug,
+		&category.CreatedAt,
+		&category.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &category, nil
+}
+
+// GetBySlug returns one category by its slug
+func (c *Category) GetBySlug(slug string) (*Category, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, category_name, slug, created_at, updated_at from categorys where slug = $1`
+
+	var category Category
+	err := db.QueryRowContext(ctx, query, slug).Scan(
+		&category.ID,
+		&category.CategoryName,
+		&category.Slug,
+		&category.CreatedAt,
+		&category.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &category, nil
+}
+
+// Insert saves a new category and returns its id, the slug is made from the name
+func (c *Category) Insert(category Category) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into categorys (category_name, slug, created_at, updated_at)
+		values ($1, $2, $3, $4) returning id`
+
+	var newID int
+	err := db.QueryRowContext(ctx, stmt,
+		category.CategoryName,
+		slugify.Slugify(category.CategoryName),
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// Update renames a category, the slug follows the new name. It returns sql.ErrNoRows when there is no such category
+func (c *Category) Update() error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update categorys set
+		category_name = $1,
+		slug = $2,
+		updated_at = $3
+		where id = $4`
+
+	result, err := db.ExecContext(ctx, stmt,
+		c.CategoryName,
+		slugify.Slugify(c.CategoryName),
+		time.Now(),
+		c.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
+
+// DeleteByID deletes a category. When the category is still used by blogs it returns ErrCategoryInUse,
+// unless cascade is true in which case the category is taken off those blogs first
+func (c *Category) DeleteByID(id int, cascade bool) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// a transaction on its own doesn't stop a blog from being added to the category between the check and the
+	// delete. Locking the category row does: adding a blog to it needs a key share lock on the row for the
+	// foreign key, so that waits until we are done and then fails because the category is gone
+	return withTransaction(ctx, func(tx *sql.Tx) error {
+		var lockedID int
+		err := tx.QueryRowContext(ctx, `select id from categorys where id = $1 for update`, id).Scan(&lockedID)
+		if err != nil {
+			return err
+		}
+
+		var inUse bool
+		err = tx.QueryRowContext(ctx, `select exists(select 1 from blogs_categorys where category_id = $1)`, id).Scan(&inUse)
+		if err != nil {
+			return err
+		}
+
+		if inUse {
+			if !cascade {
+				return ErrCategoryInUse
+			}
+
+			_, err = tx.ExecContext(ctx, `delete from blogs_categorys where category_id = $1`, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, `delete from categorys where id = $1`, id)
+		if err != nil {
+			return err
+		}
+
+		return expectRows(result)
+	})
+}
@@ -0,0 +1,183 @@
+package data
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math"
+	"time"
+)
+
+// Pagination is the page of results asked for in a listing
+type Pagination struct {
+	Page     int
+	PageSize int
+}
+
+// Validate makes sure the page and page size are in a sensible range
+func (p Pagination) Validate() error {
+	if p.Page < 1 || p.Page > 10_000_000 {
+		return errors.New("page must be between 1 and 10 million")
+	}
+
+	if p.PageSize < 1 || p.PageSize > 100 {
+		return errors.New("page_size must be between 1 and 100")
+	}
+
+	return nil
+}
+
+func (p Pagination) limit() int {
+	return p.PageSize
+}
+
+func (p Pagination) offset() int {
+	return (p.Page - 1) * p.PageSize
+}
+
+// blogSortSafelist are the columns blogs can be sorted on
+var blogSortSafelist = []string{"created_at", "updated_at", "published_at", "title"}
+
+// BlogFilters are everything a blog listing can be filtered, sorted and paginated on
+type BlogFilters struct {
+	Pagination
+	Sort          string     // one of blogSortSafelist, created_at when empty
+	Order         string     // asc or desc, desc when empty
+	CategorySlug  string     // only blogs in this category
+	AuthorID      int        // only blogs created by this user
+	From          *time.Time // only blogs created at or after this time
+	To            *time.Time // only blogs created before this time
+	PublishedOnly bool       // leave out blogs that are not published
+}
+
+// Validate makes sure the filters are valid, the sort column has to be in the safelist
+// because it ends up in the query as is
+func (f BlogFilters) Validate() error {
+	err := f.Pagination.Validate()
+	if err != nil {
+		return err
+	}
+
+	if f.Sort != "" && !inList(f.Sort, blogSortSafelist) {
+		return errors.New("sort must be one of created_at, updated_at, published_at or title")
+	}
+
+	if f.Order != "" && f.Order != "asc" && f.Order != "desc" {
+		return errors.New("order must be asc or desc")
+	}
+
+	if f.From != nil && f.To != nil && !f.From.Before(*f.To) {
+		return errors.New("from must be before to")
+	}
+
+	return nil
+}
+
+// fromUTC and toUTC are From and To the way blogs.created_at stores times, a timestamp without time zone in UTC
+func (f BlogFilters) fromUTC() *time.Time {
+	return inUTC(f.From)
+}
+
+func (f BlogFilters) toUTC() *time.Time {
+	return inUTC(f.To)
+}
+
+// inUTC returns t in UTC, or nil when t is nil
+func inUTC(t *time.Time) *time.Time {
+	if t == nil {
+		return nil
+	}
+
+	utc := t.UTC()
+	return &utc
+}
+
+func (f BlogFilters) sortColumn() string {
+	if inList(f.Sort, blogSortSafelist) {
+		return f.Sort
+	}
+	return "created_at"
+}
+
+func (f BlogFilters) sortDirection() string {
+	if f.Order == "asc" {
+		return "asc"
+	}
+	return "desc"
+}
+
+func inList(value string, list []string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// Metadata describes the page of results that was returned
+type Metadata struct {
+	CurrentPage  int `json:"current_page,omitempty"`
+	PageSize     int `json:"page_size,omitempty"`
+	FirstPage    int `json:"first_page,omitempty"`
+	LastPage     int `json:"last_page,omitempty"`
+	TotalRecords int `json:"total_records"`
+}
+
+// calculateMetadata works out the metadata for a page, there is none when there are no records
+func calculateMetadata(totalRecords int, p Pagination) Metadata {
+	if totalRecords == 0 {
+		return Metadata{}
+	}
+
+	return Metadata{
+		CurrentPage:  p.Page,
+		PageSize:     p.PageSize,
+		FirstPage:    1,
+		LastPage:     int(math.Ceil(float64(totalRecords) / float64(p.PageSize))),
+		TotalRecords: totalRecords,
+	}
+}
+
+// ErrInvalidCursor is returned when a cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points at a blog in the feed, the next page starts right after it.
+// Clients get it as an opaque string and should not look inside
+type Cursor struct {
+	Time      time.Time `json:"c"` // when the blog was published, or created in a feed that is not only published blogs
+	ID        int       `json:"i"`
+	Backwards bool      `json:"b,omitempty"` // read the page of newer blogs before the cursor instead
+}
+
+// CursorPage holds the cursors for the pages around a page of the feed,
+// they are empty when there is no page in that direction
+type CursorPage struct {
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+}
+
+// Encode turns the cursor into the opaque string we hand out
+func (c Cursor) Encode() string {
+	js, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(js)
+}
+
+// DecodeCursor turns a string from Encode back into a cursor
+func DecodeCursor(s string) (*Cursor, error) {
+	js, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c Cursor
+	err = json.Unmarshal(js, &c)
+	if err != nil || c.ID < 1 || c.Time.IsZero() {
+		return nil, ErrInvalidCursor
+	}
+
+	// we only hand out cursors in UTC, but a client could send the same time with another offset
+	c.Time = c.Time.UTC()
+
+	return &c, nil
+}
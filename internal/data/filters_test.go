@@ -0,0 +1,37 @@
+package data
+
+import (
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCursor_Encode(t *testing.T) {
+	c := Cursor{Time: time.Date(2023, 1, 2, 3, 4, 5, 6000, time.UTC), ID: 42, Backwards: true}
+
+	decoded, err := DecodeCursor(c.Encode())
+	if err != nil {
+		t.Fatal("failed to decode cursor", err)
+	}
+
+	if !decoded.Time.Equal(c.Time) || decoded.ID != c.ID || !decoded.Backwards {
+		t.Errorf("expected %+v but got %+v", c, decoded)
+	}
+
+	// the same time with an offset comes back in UTC, like the created_at we compare it with
+	offset, err := DecodeCursor(base64.RawURLEncoding.EncodeToString([]byte(`{"c":"2023-01-02T05:04:05+02:00","i":42}`)))
+	if err != nil {
+		t.Fatal("failed to decode cursor", err)
+	}
+	if !offset.Time.Equal(time.Date(2023, 1, 2, 3, 4, 5, 0, time.UTC)) || offset.Time.Location() != time.UTC {
+		t.Errorf("expected the cursor in UTC but got %s", offset.Time)
+	}
+
+	for _, bad := range []string{"not base64!", "bm90IGpzb24", "e30"} {
+		_, err := DecodeCursor(bad)
+		if !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("%s: expected ErrInvalidCursor but got %v", bad, err)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrExternalEmailNotVerified is returned when a provider does not vouch for the email address of somebody
+	// we don't know yet. We link accounts by email address, so we have to be sure it is theirs
+	ErrExternalEmailNotVerified = errors.New("the identity provider has not verified this email address")
+	// ErrExternalLoginRefused is returned when the account linked to an identity was deleted
+	ErrExternalLoginRefused = errors.New("this account can't log in")
+)
+
+// UserIdentity links a user to their account at an external identity provider
+type UserIdentity struct {
+	ID          int        `json:"id"`
+	UserID      int        `json:"user_id"`
+	Issuer      string     `json:"issuer"`
+	Subject     string     `json:"subject"`
+	Email       string     `json:"email"`
+	CreatedAt   time.Time  `json:"created_at"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+}
+
+// ExternalUser is who an identity provider says somebody is
+type ExternalUser struct {
+	Issuer        string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	FirstName     string
+	LastName      string
+}
+
+// LinkOrProvision returns the user for somebody who logged in with an identity provider. That is the user the
+// identity is linked to, or else the user with the same (verified) email address, who gets linked now.
+// When there is no such user an account is created with role, the user can set a password later
+// with the forgot password link if they want one
+func (i *UserIdentity) LinkOrProvision(external ExternalUser, role string) (*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var user *User
+
+	err := withTransaction(ctx, func(tx *sql.Tx) error {
+		var userID int
+		err := tx.QueryRowContext(ctx, `select user_id from user_identities where issuer = $1 and subject = $2`,
+			external.Issuer, external.Subject).Scan(&userID)
+
+		switch {
+		case err == nil:
+			user, err = getUserByID(ctx, tx, userID)
+			if errors.Is(err, sql.ErrNoRows) {
+				return ErrExternalLoginRefused
+			}
+			if err != nil {
+				return err
+			}
+
+			_, err = tx.ExecContext(ctx, `update user_identities set email = $1, last_login_at = $2 where issuer = $3 and subject = $4`,
+				external.Email, time.Now(), external.Issuer, external.Subject)
+			return err
+
+		case !errors.Is(err, sql.ErrNoRows):
+			return err
+		}
+
+		// somebody new, they are matched by email address
+		if !external.EmailVerified || external.Email == "" {
+			return ErrExternalEmailNotVerified
+		}
+
+		err = tx.QueryRowContext(ctx, `select id from users where lower(email) = lower($1) and deleted_at is null`,
+			external.Email).Scan(&userID)
+		if errors.Is(err, sql.ErrNoRows) {
+			userID, err = provisionUser(ctx, tx, external, role)
+		}
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `insert into user_identities (user_id, issuer, subject, email, created_at, last_login_at)
+			values ($1, $2, $3, $4, $5, $5)`,
+			userID, external.Issuer, external.Subject, external.Email, time.Now())
+		if err != nil {
+			return err
+		}
+
+		user, err = getUserByID(ctx, tx, userID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+// provisionUser creates an active account for external. It gets a random password nobody knows
+func provisionUser(ctx context.Context, q dbtx, external ExternalUser, role string) (int, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return 0, err
+	}
+
+	// the provider checked the email address already
+	now := time.Now()
+
+	return insertUser(ctx, q, User{
+		Email:           external.Email,
+		FirstName:       external.FirstName,
+		LastName:        external.LastName,
+		Password:        base64.RawStdEncoding.EncodeToString(randomBytes),
+		Active:          1,
+		Role:            role,
+		EmailVerifiedAt: &now,
+	})
+}
+
+// getUserByID is User.GetByID on q
+func getUserByID(ctx context.Context, q dbtx, id int) (*User, error) {
+	query := `select id, email, first_name, last_name, user_active, role, created_at, updated_at
+		from users where id = $1 and deleted_at is null`
+
+	var user User
+	err := q.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.Email,
+		&user.FirstName,
+		&user.LastName,
+		&user.Active,
+		&user.Role,
+		&user.CreatedAt,
+		&user.UpdatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &user, nil
+}
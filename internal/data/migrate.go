@@ -0,0 +1,266 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// the migrations are compiled into the binary, so there is nothing to copy next to it when deploying
+//
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLockID is the key of the postgres advisory lock held while migrating,
+// so two instances starting at the same time don't both try to migrate
+const migrationLockID = 7310483391
+
+// Migration is one versioned change to the schema, read from migrations/<version>_<name>.up.sql
+// and the matching .down.sql
+type Migration struct {
+	Version int
+	Name    string
+	up      string
+	down    string
+}
+
+// loadMigrations reads all the embedded migrations, sorted by version
+func loadMigrations() ([]Migration, error) {
+	files, err := fs.Glob(migrationFiles, "migrations/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+
+	for _, file := range files {
+		base := strings.TrimPrefix(file, "migrations/")
+
+		// 000001_create_initial_schema.up.sql -> 000001, create_initial_schema, up
+		name, direction, ok := cutSuffixes(base, ".up.sql", ".down.sql")
+		if !ok {
+			return nil, fmt.Errorf("migration %s is neither an up nor a down migration", base)
+		}
+
+		versionPart, name, ok := strings.Cut(name, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration %s has no version", base)
+		}
+
+		version, err := strconv.Atoi(versionPart)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has an invalid version: %s", base, err.Error())
+		}
+
+		content, err := migrationFiles.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+		}
+
+		if m.Name != name {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, m.Name, name)
+		}
+
+		if direction == ".up.sql" {
+			m.up = string(content)
+		} else {
+			m.down = string(content)
+		}
+	}
+
+	var migrations []Migration
+	for _, m := range byVersion {
+		if m.up == "" || m.down == "" {
+			return nil, fmt.Errorf("migration %d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// cutSuffixes removes the first of suffixes that s ends with, and reports which one it was
+func cutSuffixes(s string, suffixes ...string) (string, string, bool) {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return strings.TrimSuffix(s, suffix), suffix, true
+		}
+	}
+	return s, "", false
+}
+
+// migrator runs migrations on one connection, which holds the advisory lock for as long as it runs
+type migrator struct {
+	conn       *sql.Conn
+	migrations []Migration
+}
+
+// withMigrator takes the migration lock on a connection of dbPool, makes sure the schema_migrations table exists
+// and runs fn. Anybody else trying to migrate at the same time waits until fn is done
+func withMigrator(ctx context.Context, dbPool *sql.DB, fn func(m *migrator) error) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	// advisory locks belong to a session, so the lock, the migrations and the unlock all have to use the same connection
+	conn, err := dbPool.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.ExecContext(ctx, `select pg_advisory_lock($1)`, migrationLockID)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_, _ = conn.ExecContext(context.Background(), `select pg_advisory_unlock($1)`, migrationLockID)
+	}()
+
+	_, err = conn.ExecContext(ctx, `create table if not exists schema_migrations (
+		version bigint not null primary key,
+		name character varying(255) not null,
+		applied_at timestamp with time zone not null
+	)`)
+	if err != nil {
+		return err
+	}
+
+	return fn(&migrator{conn: conn, migrations: migrations})
+}
+
+// applied returns the versions in schema_migrations, oldest first
+func (m *migrator) applied(ctx context.Context) ([]int, error) {
+	rows, err := m.conn.QueryContext(ctx, `select version from schema_migrations order by version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []int
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+// run executes the sql of one migration and records it in schema_migrations in the same transaction,
+// so a migration that fails halfway leaves nothing behind
+func (m *migrator) run(ctx context.Context, migration Migration, up bool) error {
+	tx, err := m.conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := migration.down
+	if up {
+		stmt = migration.up
+	}
+
+	_, err = tx.ExecContext(ctx, stmt)
+	if err != nil {
+		return fmt.Errorf("migration %d_%s: %s", migration.Version, migration.Name, err.Error())
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, `insert into schema_migrations (version, name, applied_at) values ($1, $2, $3)`,
+			migration.Version, migration.Name, time.Now())
+	} else {
+		_, err = tx.ExecContext(ctx, `delete from schema_migrations where version = $1`, migration.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MigrateUp applies every migration that has not been applied yet, in order, and returns the ones it applied
+func MigrateUp(dbPool *sql.DB) ([]Migration, error) {
+	ctx := context.Background()
+	var done []Migration
+
+	err := withMigrator(ctx, dbPool, func(m *migrator) error {
+		versions, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		isApplied := make(map[int]bool)
+		for _, version := range versions {
+			isApplied[version] = true
+		}
+
+		for _, migration := range m.migrations {
+			if isApplied[migration.Version] {
+				continue
+			}
+
+			if err := m.run(ctx, migration, true); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// MigrateDown rolls back the last steps applied migrations, newest first, and returns the ones it rolled back
+func MigrateDown(dbPool *sql.DB, steps int) ([]Migration, error) {
+	ctx := context.Background()
+	var done []Migration
+
+	err := withMigrator(ctx, dbPool, func(m *migrator) error {
+		versions, err := m.applied(ctx)
+		if err != nil {
+			return err
+		}
+
+		byVersion := make(map[int]Migration)
+		for _, migration := range m.migrations {
+			byVersion[migration.Version] = migration
+		}
+
+		for i := len(versions) - 1; i >= 0 && len(done) < steps; i-- {
+			migration, ok := byVersion[versions[i]]
+			if !ok {
+				return fmt.Errorf("migration %d was applied, but this build does not know about it", versions[i])
+			}
+
+			if err := m.run(ctx, migration, false); err != nil {
+				return err
+			}
+			done = append(done, migration)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
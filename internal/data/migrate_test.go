@@ -0,0 +1,50 @@
+package data
+
+import "testing"
+
+func Test_loadMigrations(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(migrations) == 0 {
+		t.Fatal("expected embedded migrations but found none")
+	}
+
+	for i, m := range migrations {
+		if m.Version != i+1 {
+			t.Errorf("expected migration %d to have version %d but got %d (%s)", i, i+1, m.Version, m.Name)
+		}
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	// TestMain already migrated, so there is nothing left to do
+	migrations, err := MigrateUp(testDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 0 {
+		t.Errorf("expected no migrations to run on an up to date database but %d did", len(migrations))
+	}
+
+	all, _ := loadMigrations()
+	last := all[len(all)-1]
+
+	migrations, err = MigrateDown(testDB, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 1 || migrations[0].Version != last.Version {
+		t.Errorf("expected only migration %d to be rolled back but got %v", last.Version, migrations)
+	}
+
+	migrations, err = MigrateUp(testDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 1 || migrations[0].Version != last.Version {
+		t.Errorf("expected only migration %d to be applied again but got %v", last.Version, migrations)
+	}
+}
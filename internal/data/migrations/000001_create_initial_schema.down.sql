@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS public.blogs_categorys;
+DROP TABLE IF EXISTS public.blogs;
+DROP TABLE IF EXISTS public.categorys;
+DROP TABLE IF EXISTS public.tokens;
+DROP TABLE IF EXISTS public.users;
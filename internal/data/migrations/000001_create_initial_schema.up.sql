@@ -0,0 +1,51 @@
+-- the schema as it was before we had migrations, every statement is guarded
+-- so databases that were set up by hand can adopt the migrations as they are
+
+CREATE TABLE IF NOT EXISTS public.users (
+    id integer NOT NULL GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    email character varying(255),
+    first_name character varying(255) NOT NULL,
+    last_name character varying(255) NOT NULL,
+    password character varying(60) NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL,
+    user_active integer DEFAULT 0
+);
+
+CREATE TABLE IF NOT EXISTS public.tokens (
+    id integer NOT NULL GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer,
+    email character varying(255) NOT NULL,
+    token character varying(255) NOT NULL,
+    token_hash bytea NOT NULL,
+    expiry timestamp with time zone NOT NULL,
+    created_at timestamp without time zone NOT NULL,
+    updated_at timestamp without time zone NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.categorys (
+    id integer NOT NULL GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    category_name character varying(255),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
+
+CREATE TABLE IF NOT EXISTS public.blogs (
+    id integer NOT NULL GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    title character varying(512),
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone,
+    slug character varying(512),
+    description text,
+    created_by character varying(255),
+    content text,
+    createdby_id integer NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS public.blogs_categorys (
+    id integer NOT NULL GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    blog_id integer,
+    category_id integer,
+    created_at timestamp without time zone,
+    updated_at timestamp without time zone
+);
@@ -0,0 +1,11 @@
+-- before roles every user could do everything, so the users we already have become admins
+-- and nobody gets locked out. A database that already has the column is left alone
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = 'public' AND table_name = 'users' AND column_name = 'role') THEN
+        ALTER TABLE public.users ADD COLUMN role character varying(20) DEFAULT 'reader' NOT NULL;
+        UPDATE public.users SET role = 'admin';
+    END IF;
+END
+$$;
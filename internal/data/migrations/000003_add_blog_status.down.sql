@@ -0,0 +1,4 @@
+ALTER TABLE public.blogs
+    DROP COLUMN IF EXISTS status,
+    DROP COLUMN IF EXISTS publish_at,
+    DROP COLUMN IF EXISTS published_at;
@@ -0,0 +1,14 @@
+-- every blog we already had was public, so it starts out published.
+-- A database that already has the columns is left alone
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+                   WHERE table_schema = 'public' AND table_name = 'blogs' AND column_name = 'status') THEN
+        ALTER TABLE public.blogs
+            ADD COLUMN status character varying(20) DEFAULT 'draft' NOT NULL,
+            ADD COLUMN publish_at timestamp with time zone,
+            ADD COLUMN published_at timestamp with time zone;
+        UPDATE public.blogs SET status = 'published', published_at = coalesce(created_at, now());
+    END IF;
+END
+$$;
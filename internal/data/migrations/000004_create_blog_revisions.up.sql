@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS public.blog_revisions (
+    id integer NOT NULL GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    blog_id integer NOT NULL,
+    revision integer NOT NULL,
+    title character varying(512),
+    description text,
+    content text,
+    author_id integer,
+    created_at timestamp without time zone NOT NULL,
+    UNIQUE (blog_id, revision)
+);
+
+-- the blogs as they are now are the first revision
+INSERT INTO public.blog_revisions (blog_id, revision, title, description, content, author_id, created_at)
+SELECT b.id, 1, b.title, b.description, b.content, b.createdby_id, coalesce(b.updated_at, now())
+FROM public.blogs b
+WHERE NOT EXISTS (SELECT 1 FROM public.blog_revisions r WHERE r.blog_id = b.id);
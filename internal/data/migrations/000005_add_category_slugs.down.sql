@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.categorys_slug_key;
+ALTER TABLE public.categorys DROP COLUMN IF EXISTS slug;
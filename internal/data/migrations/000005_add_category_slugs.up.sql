@@ -0,0 +1,7 @@
+ALTER TABLE public.categorys ADD COLUMN IF NOT EXISTS slug character varying(255);
+
+UPDATE public.categorys
+SET slug = trim(both '-' from lower(regexp_replace(category_name, '[^a-zA-Z0-9]+', '-', 'g')))
+WHERE slug IS NULL;
+
+CREATE UNIQUE INDEX IF NOT EXISTS categorys_slug_key ON public.categorys (slug);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.blogs_search_vector_idx;
+ALTER TABLE public.blogs DROP COLUMN IF EXISTS search_vector;
@@ -0,0 +1,8 @@
+ALTER TABLE public.blogs ADD COLUMN IF NOT EXISTS search_vector tsvector;
+
+UPDATE public.blogs SET search_vector =
+    setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+    setweight(to_tsvector('english', coalesce(description, '')), 'B') ||
+    setweight(to_tsvector('english', coalesce(content, '')), 'C');
+
+CREATE INDEX IF NOT EXISTS blogs_search_vector_idx ON public.blogs USING gin (search_vector);
@@ -0,0 +1,19 @@
+DROP INDEX IF EXISTS public.blog_revisions_author_id_idx;
+DROP INDEX IF EXISTS public.tokens_user_id_idx;
+DROP INDEX IF EXISTS public.blogs_categorys_category_id_idx;
+DROP INDEX IF EXISTS public.blogs_categorys_blog_id_idx;
+DROP INDEX IF EXISTS public.blogs_createdby_id_idx;
+
+ALTER TABLE public.blog_revisions
+    DROP CONSTRAINT IF EXISTS blog_revisions_author_id_fkey,
+    DROP CONSTRAINT IF EXISTS blog_revisions_blog_id_fkey;
+
+ALTER TABLE public.tokens DROP CONSTRAINT IF EXISTS tokens_user_id_fkey;
+
+ALTER TABLE public.blogs_categorys
+    DROP CONSTRAINT IF EXISTS blogs_categorys_category_id_fkey,
+    DROP CONSTRAINT IF EXISTS blogs_categorys_blog_id_fkey,
+    ALTER COLUMN category_id DROP NOT NULL,
+    ALTER COLUMN blog_id DROP NOT NULL;
+
+ALTER TABLE public.blogs DROP CONSTRAINT IF EXISTS blogs_createdby_id_fkey;
@@ -0,0 +1,43 @@
+-- rows pointing at something that is already gone can't get a foreign key,
+-- these are safe to clean up since nothing can ever reach them again
+DELETE FROM public.blogs_categorys bc
+WHERE NOT EXISTS (SELECT 1 FROM public.blogs b WHERE b.id = bc.blog_id)
+   OR NOT EXISTS (SELECT 1 FROM public.categorys c WHERE c.id = bc.category_id);
+
+DELETE FROM public.tokens t
+WHERE NOT EXISTS (SELECT 1 FROM public.users u WHERE u.id = t.user_id);
+
+DELETE FROM public.blog_revisions r
+WHERE NOT EXISTS (SELECT 1 FROM public.blogs b WHERE b.id = r.blog_id);
+
+UPDATE public.blog_revisions r SET author_id = NULL
+WHERE author_id IS NOT NULL AND NOT EXISTS (SELECT 1 FROM public.users u WHERE u.id = r.author_id);
+
+-- every blog needs an author, so the blogs of a user have to be reassigned or deleted before the user can go.
+-- Blogs that already lost their author make this migration fail, someone has to decide who owns them now
+ALTER TABLE public.blogs
+    ADD CONSTRAINT blogs_createdby_id_fkey FOREIGN KEY (createdby_id) REFERENCES public.users (id) ON DELETE RESTRICT;
+
+-- deleting a blog takes its category assignments with it, deleting a category that is still
+-- used is refused, Category.DeleteByID unassigns it first when asked to
+ALTER TABLE public.blogs_categorys
+    ALTER COLUMN blog_id SET NOT NULL,
+    ALTER COLUMN category_id SET NOT NULL,
+    ADD CONSTRAINT blogs_categorys_blog_id_fkey FOREIGN KEY (blog_id) REFERENCES public.blogs (id) ON DELETE CASCADE,
+    ADD CONSTRAINT blogs_categorys_category_id_fkey FOREIGN KEY (category_id) REFERENCES public.categorys (id) ON DELETE RESTRICT;
+
+-- the sessions of a user go with the user
+ALTER TABLE public.tokens
+    ADD CONSTRAINT tokens_user_id_fkey FOREIGN KEY (user_id) REFERENCES public.users (id) ON DELETE CASCADE;
+
+-- the history of a blog goes with the blog, but stays when the author of a revision is deleted
+ALTER TABLE public.blog_revisions
+    ADD CONSTRAINT blog_revisions_blog_id_fkey FOREIGN KEY (blog_id) REFERENCES public.blogs (id) ON DELETE CASCADE,
+    ADD CONSTRAINT blog_revisions_author_id_fkey FOREIGN KEY (author_id) REFERENCES public.users (id) ON DELETE SET NULL;
+
+-- foreign keys are not indexed by postgres, these keep the cascades and the lookups by owner fast
+CREATE INDEX IF NOT EXISTS blogs_createdby_id_idx ON public.blogs (createdby_id);
+CREATE INDEX IF NOT EXISTS blogs_categorys_blog_id_idx ON public.blogs_categorys (blog_id);
+CREATE INDEX IF NOT EXISTS blogs_categorys_category_id_idx ON public.blogs_categorys (category_id);
+CREATE INDEX IF NOT EXISTS tokens_user_id_idx ON public.tokens (user_id);
+CREATE INDEX IF NOT EXISTS blog_revisions_author_id_idx ON public.blog_revisions (author_id);
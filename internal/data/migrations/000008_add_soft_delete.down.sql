@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS public.users_deleted_at_idx;
+DROP INDEX IF EXISTS public.blogs_deleted_at_idx;
+
+-- blogs in the trash are gone for good once the column is, users in the trash
+-- come back but stay inactive, they may still have blogs
+DELETE FROM public.blogs WHERE deleted_at IS NOT NULL;
+ALTER TABLE public.blogs DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE public.users DROP COLUMN IF EXISTS deleted_at;
@@ -0,0 +1,7 @@
+-- blogs and users are not deleted right away, they go to the trash first
+ALTER TABLE public.blogs ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS deleted_at timestamp with time zone;
+
+-- the trash is small compared to everything else, these keep listing and purging it cheap
+CREATE INDEX IF NOT EXISTS blogs_deleted_at_idx ON public.blogs (deleted_at) WHERE deleted_at IS NOT NULL;
+CREATE INDEX IF NOT EXISTS users_deleted_at_idx ON public.users (deleted_at) WHERE deleted_at IS NOT NULL;
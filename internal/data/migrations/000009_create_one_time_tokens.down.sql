@@ -0,0 +1 @@
+DROP TABLE IF EXISTS public.one_time_tokens;
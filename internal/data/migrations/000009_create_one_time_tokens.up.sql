@@ -0,0 +1,13 @@
+-- single use tokens that are emailed to users, like password reset links.
+-- only the sha256 hash of a token is stored, the plain text only ever exists in the email
+CREATE TABLE IF NOT EXISTS public.one_time_tokens (
+    id integer NOT NULL GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    purpose character varying(32) NOT NULL,
+    token_hash bytea NOT NULL UNIQUE,
+    expiry timestamp with time zone NOT NULL,
+    used_at timestamp with time zone,
+    created_at timestamp with time zone NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS one_time_tokens_user_id_idx ON public.one_time_tokens (user_id, purpose);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS public.users_unverified_idx;
+ALTER TABLE public.users DROP COLUMN IF EXISTS email_verified_at;
@@ -0,0 +1,6 @@
+ALTER TABLE public.users ADD COLUMN IF NOT EXISTS email_verified_at timestamp with time zone;
+
+-- everybody we already had was added by an admin, nobody has to verify those
+UPDATE public.users SET email_verified_at = created_at WHERE email_verified_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS users_unverified_idx ON public.users (created_at) WHERE email_verified_at IS NULL;
@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS public.tokens_token_hash_key;
+
+-- the plain text of the tokens we have is gone, so everybody has to log in again
+DELETE FROM public.tokens;
+ALTER TABLE public.tokens ADD COLUMN IF NOT EXISTS token character varying(255) NOT NULL;
@@ -0,0 +1,4 @@
+-- tokens are looked up by their sha256 hash, the plain text is only ever sent to the user
+ALTER TABLE public.tokens DROP COLUMN IF EXISTS token;
+
+CREATE UNIQUE INDEX IF NOT EXISTS tokens_token_hash_key ON public.tokens (token_hash);
@@ -0,0 +1,4 @@
+ALTER TABLE public.tokens
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS ip,
+    DROP COLUMN IF EXISTS last_used_at;
@@ -0,0 +1,5 @@
+-- every token is a session, these tell the user which device it is and when it was last used
+ALTER TABLE public.tokens
+    ADD COLUMN IF NOT EXISTS user_agent text NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS ip character varying(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS last_used_at timestamp with time zone;
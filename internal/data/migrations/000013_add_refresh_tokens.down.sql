@@ -0,0 +1,9 @@
+-- refresh tokens can't be used as bearer tokens, so they go
+DELETE FROM public.tokens WHERE scope <> 'access';
+
+DROP INDEX IF EXISTS tokens_family_idx;
+
+ALTER TABLE public.tokens
+    DROP COLUMN IF EXISTS scope,
+    DROP COLUMN IF EXISTS family,
+    DROP COLUMN IF EXISTS used_at;
@@ -0,0 +1,12 @@
+-- a login now gives a short lived access token and a refresh token, both belong to the same family.
+-- the family is the session, refreshing swaps the refresh token for a new one in the same family
+ALTER TABLE public.tokens
+    ADD COLUMN IF NOT EXISTS scope character varying(16) NOT NULL DEFAULT 'access',
+    ADD COLUMN IF NOT EXISTS family character varying(64) NOT NULL DEFAULT '',
+    ADD COLUMN IF NOT EXISTS used_at timestamp with time zone;
+
+-- the tokens from before are access tokens, each its own session
+UPDATE public.tokens SET family = 'token-' || id WHERE family = '';
+ALTER TABLE public.tokens ALTER COLUMN family DROP DEFAULT;
+
+CREATE INDEX IF NOT EXISTS tokens_family_idx ON public.tokens (family);
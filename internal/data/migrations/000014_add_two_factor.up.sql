@@ -0,0 +1,17 @@
+-- totp_secret is set when a user starts setting up two factor authentication, it is only in use
+-- once totp_enabled_at is set. totp_last_step is the time step of the last code used, so a code works only once
+ALTER TABLE public.users
+    ADD COLUMN IF NOT EXISTS totp_secret character varying(64),
+    ADD COLUMN IF NOT EXISTS totp_enabled_at timestamp with time zone,
+    ADD COLUMN IF NOT EXISTS totp_last_step bigint NOT NULL DEFAULT 0;
+
+-- codes to log in with when the authenticator app is lost, each works once. only the hash is stored
+CREATE TABLE IF NOT EXISTS public.recovery_codes (
+    id integer NOT NULL GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    code_hash bytea NOT NULL,
+    used_at timestamp with time zone,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS recovery_codes_user_id_idx ON public.recovery_codes (user_id);
@@ -0,0 +1,13 @@
+-- passkeys, a user can have one for every device. public_key is the COSE key the authenticator sent
+CREATE TABLE IF NOT EXISTS public.webauthn_credentials (
+    id integer NOT NULL GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    credential_id bytea NOT NULL UNIQUE,
+    public_key bytea NOT NULL,
+    sign_count bigint NOT NULL DEFAULT 0,
+    name character varying(255) NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL,
+    last_used_at timestamp with time zone
+);
+
+CREATE INDEX IF NOT EXISTS webauthn_credentials_user_id_idx ON public.webauthn_credentials (user_id);
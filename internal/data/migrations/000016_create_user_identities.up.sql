@@ -0,0 +1,14 @@
+-- logins with an external identity provider. the provider (issuer) and its id for the user (subject)
+-- never change, unlike the email address, so that is what we recognise somebody by
+CREATE TABLE IF NOT EXISTS public.user_identities (
+    id integer NOT NULL GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id integer NOT NULL REFERENCES public.users (id) ON DELETE CASCADE,
+    issuer character varying(255) NOT NULL,
+    subject character varying(255) NOT NULL,
+    email character varying(255) NOT NULL,
+    created_at timestamp with time zone NOT NULL,
+    last_login_at timestamp with time zone,
+    UNIQUE (issuer, subject)
+);
+
+CREATE INDEX IF NOT EXISTS user_identities_user_id_idx ON public.user_identities (user_id);
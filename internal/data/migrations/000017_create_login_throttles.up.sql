@@ -0,0 +1,8 @@
+-- failed logins, counted per email address (account:...) and per ip address (ip:...). the email address is
+-- used rather than the user id so somebody guessing email addresses can't tell which ones have an account
+CREATE TABLE IF NOT EXISTS public.login_throttles (
+    key character varying(320) NOT NULL PRIMARY KEY,
+    failures integer NOT NULL DEFAULT 0,
+    last_failure_at timestamp with time zone NOT NULL,
+    blocked_until timestamp with time zone
+);
@@ -0,0 +1,4 @@
+-- registering checked for the email address before inserting, two sign ups at the same time could both get
+-- through. we compare email addresses without looking at case everywhere, so the index does the same.
+-- users in the trash keep their address, it comes back with them when they are restored
+CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_idx ON public.users (lower(email));
@@ -6,11 +6,13 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"encoding/hex"
 	"errors"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"golang.org/x/crypto/bcrypt"
 )
 
@@ -23,28 +25,91 @@ func New(dbPool *sql.DB) Models {
 	db = dbPool
 
 	return Models{
-		User:  User{},
-		Token: Token{},
-		Blog:  Blog{},
+		User:               User{},
+		Token:              Token{},
+		Blog:               Blog{},
+		BlogRevision:       BlogRevision{},
+		Category:           Category{},
+		OneTimeToken:       OneTimeToken{},
+		WebAuthnCredential: WebAuthnCredential{},
+		UserIdentity:       UserIdentity{},
+		LoginThrottle:      LoginThrottle{},
 	}
 }
 
 type Models struct {
-	User  User
-	Token Token
-	Blog  Blog
+	User               User
+	Token              Token
+	Blog               Blog
+	BlogRevision       BlogRevision
+	Category           Category
+	OneTimeToken       OneTimeToken
+	WebAuthnCredential WebAuthnCredential
+	UserIdentity       UserIdentity
+	LoginThrottle      LoginThrottle
 }
 
+// Roles a user can have, stored in the role column of the users table.
+// admins can do everything, editors can manage every blog, authors can only
+// manage their own blogs and readers have no access to the admin routes
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleAuthor = "author"
+	RoleReader = "reader"
+)
+
+// ErrDuplicateEmail is returned when a user is saved with an email address somebody already has,
+// upper and lower case don't count
+var ErrDuplicateEmail = errors.New("an account with that email address already exists")
+
+// ErrUserHasBlogs is returned when deleting a user who still has blogs without saying who gets them
+var ErrUserHasBlogs = errors.New("user still has blogs, reassign them to another user first")
+
+// ErrInvalidReassignUser is returned when the blogs of a deleted user would go to someone who can't own blogs
+var ErrInvalidReassignUser = errors.New("blogs can only be reassigned to another admin, editor or author")
+
+// ErrInvalidRefreshToken is returned when a refresh token does not exist, has expired or its user can't log in anymore
+var ErrInvalidRefreshToken = errors.New("invalid or expired refresh token")
+
+// ErrRefreshTokenReused is returned when a refresh token is used a second time. Only one of the two
+// can be the real user, so the whole session is revoked and they have to log in again
+var ErrRefreshTokenReused = errors.New("refresh token was already used, the session has been revoked")
+
 type User struct {
-	ID        int       `json:"id"`
-	Email     string    `json:"email"`
-	FirstName string    `json:"first_name,omitempty"`
-	LastName  string    `json:"last_name,omitempty"`
-	Password  string    `json:"password"`
-	Active    int       `json:"active"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
-	Token     Token     `json:"token"`
+	ID        int        `json:"id"`
+	Email     string     `json:"email"`
+	FirstName string     `json:"first_name,omitempty"`
+	LastName  string     `json:"last_name,omitempty"`
+	Password  string     `json:"password"`
+	Active    int        `json:"active"`
+	Role      string     `json:"role"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	Token     Token      `json:"token"`
+
+	// EmailVerifiedAt is nil for someone who registered and did not follow the link in the verification email yet
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+}
+
+// ValidRole reports whether role is one of the roles we know about
+func ValidRole(role string) bool {
+	switch role {
+	case RoleAdmin, RoleEditor, RoleAuthor, RoleReader:
+		return true
+	}
+	return false
+}
+
+// HasRole reports whether the user has any of the given roles
+func (u *User) HasRole(roles ...string) bool {
+	for _, role := range roles {
+		if u.Role == role {
+			return true
+		}
+	}
+	return false
 }
 
 // Query to get all users
@@ -55,12 +120,12 @@ func (u *User) GetAll() ([]*User, error) {
 	defer cancel()
 
 	// SQL query statement that will be passed as a parameter to our db Query context
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at , 
+	query := `select id, email, first_name, last_name, password, user_active, role, created_at, updated_at , 
 	case 
 		when (select count(id) from tokens t where user_id = users.id and t.expiry > NOW()) > 0 then 1
 		else 0
 	end as has_token
-	from users order by last_name`
+	from users where deleted_at is null order by last_name`
 
 	// Query every row for users and close when  done
 	rows, err := db.QueryContext(ctx, query)
@@ -82,6 +147,7 @@ func (u *User) GetAll() ([]*User, error) {
 			&user.LastName,
 			&user.Password,
 			&user.Active,
+			&user.Role,
 			&user.CreatedAt,
 			&user.UpdatedAt,
 			&user.Token.ID,
@@ -104,7 +170,8 @@ func (u *User) GetByEmail(email string) (*User, error) {
 	defer cancel()
 
 	//SQL query
-	query := `select id, email, first_name, last_name, password, created_at, updated_at, user_active from users where email = $1`
+	query := `select id, email, first_name, last_name, password, created_at, updated_at, user_active, role, email_verified_at
+		from users where lower(email) = lower($1) and deleted_at is null`
 
 	// variable for User type the function will return
 	var user User
@@ -123,6 +190,8 @@ func (u *User) GetByEmail(email string) (*User, error) {
 		&user.CreatedAt,
 		&user.UpdatedAt,
 		&user.Active,
+		&user.Role,
+		&user.EmailVerifiedAt,
 	)
 
 	// if error
@@ -139,7 +208,7 @@ func (u *User) GetByID(id int) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where id = $1`
+	query := `select id, email, first_name, last_name, password, user_active, role, created_at, updated_at from users where id = $1 and deleted_at is null`
 
 	// variable for User type the function will return
 	var user User
@@ -155,6 +224,7 @@ func (u *User) GetByID(id int) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -181,8 +251,9 @@ func (u *User) Update() error {
 			first_name = $2,
 			last_name = $3,
 			user_active = $4,
-			updated_at =$5
-			where id = $6
+			role = $5,
+			updated_at =$6
+			where id = $7
 			`
 
 	//ExecContext doesnt return anything, we just want to query for errors and nit return anything
@@ -191,6 +262,7 @@ func (u *User) Update() error {
 		u.FirstName,
 		u.LastName,
 		u.Active,
+		u.Role,
 		time.Now(),
 		u.ID,
 	)
@@ -220,21 +292,224 @@ func (u *User) Delete() error {
 }
 
 // Delete by ID
-// compared to the delete func this deletes the id instantly instead of first making a call to the db
-func (u *User) DeleteByID(id int) error {
+// compared to the delete func this moves the user to the trash instantly instead of first making a call to the db.
+// The user is logged out and can't log in until they are restored. When reassignTo is not 0 the blogs of the user
+// are given to that user right away, otherwise they stay with the deleted user until the user is purged
+func (u *User) DeleteByID(id, reassignTo int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `delete from users where id = $1`
+	return withTransaction(ctx, func(tx *sql.Tx) error {
+		if reassignTo != 0 {
+			err := reassignBlogs(ctx, tx, id, reassignTo)
+			if err != nil {
+				return err
+			}
+		}
 
-	_, err := db.ExecContext(ctx, stmt, id)
+		result, err := tx.ExecContext(ctx, `update users set deleted_at = $1, user_active = 0 where id = $2 and deleted_at is null`, time.Now(), id)
+		if err != nil {
+			return err
+		}
+
+		err = expectRows(result)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from tokens where user_id = $1`, id)
+		return err
+	})
+}
+
+// Purge permanently deletes a user that is in the trash.
+// A user who still has blogs can only be purged when reassignTo is the id of someone who takes the blogs over,
+// otherwise ErrUserHasBlogs is returned
+func (u *User) Purge(id, reassignTo int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return withTransaction(ctx, func(tx *sql.Tx) error {
+		if reassignTo != 0 {
+			err := reassignBlogs(ctx, tx, id, reassignTo)
+			if err != nil {
+				return err
+			}
+		} else {
+			var hasBlogs bool
+			err := tx.QueryRowContext(ctx, `select exists(select 1 from blogs where createdby_id = $1)`, id).Scan(&hasBlogs)
+			if err != nil {
+				return err
+			}
+
+			if hasBlogs {
+				return ErrUserHasBlogs
+			}
+		}
+
+		result, err := tx.ExecContext(ctx, `delete from users where id = $1 and deleted_at is not null`, id)
+		if err != nil {
+			return err
+		}
+
+		return expectRows(result)
+	})
+}
+
+// reassignBlogs gives all the blogs of user id to user reassignTo,
+// who has to be someone else who is allowed to write blogs
+func reassignBlogs(ctx context.Context, tx *sql.Tx, id, reassignTo int) error {
+	var role string
+	err := tx.QueryRowContext(ctx, `select role from users where id = $1 and deleted_at is null`, reassignTo).Scan(&role)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	if reassignTo == id || !(&User{Role: role}).HasRole(RoleAdmin, RoleEditor, RoleAuthor) {
+		return ErrInvalidReassignUser
+	}
+
+	_, err = tx.ExecContext(ctx, `update blogs set createdby_id = $1 where createdby_id = $2`, reassignTo, id)
+	return err
+}
+
+// GetTrashed returns every user in the trash, the most recently deleted first
+func (u *User) GetTrashed() ([]*User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, email, first_name, last_name, role, created_at, updated_at, deleted_at
+		from users where deleted_at is not null order by deleted_at desc`
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var users []*User
+
+	for rows.Next() {
+		var user User
+		err := rows.Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Role,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+			&user.DeletedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		users = append(users, &user)
+	}
+
+	return users, rows.Err()
+}
+
+// Restore takes a user out of the trash. The user stays inactive until an admin activates them again
+func (u *User) Restore(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
 
+	result, err := db.ExecContext(ctx, `update users set deleted_at = null where id = $1 and deleted_at is not null`, id)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return expectRows(result)
+}
+
+// PurgeTrashed permanently deletes every user that was put in the trash before before and returns how many
+// users were deleted. Users who still have blogs are kept, they need an admin to decide who gets their blogs
+func (u *User) PurgeTrashed(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from users u where u.deleted_at < $1
+		and not exists (select 1 from blogs b where b.createdby_id = u.id)`
+
+	result, err := db.ExecContext(ctx, stmt, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// EmailExists reports whether somebody already uses email, users in the trash included.
+// Email addresses are compared without looking at upper and lower case
+func (u *User) EmailExists(email string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var exists bool
+	err := db.QueryRowContext(ctx, `select exists(select 1 from users where lower(email) = lower($1))`, email).Scan(&exists)
+	if err != nil {
+		return false, err
+	}
+
+	return exists, nil
+}
+
+// RestartRegistration gives somebody who registered again before verifying their email address the name and
+// password they used this time, the last one to register is who follows the link. It returns sql.ErrNoRows when
+// the account was verified in the meantime
+func (u *User) RestartRegistration(user User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
+	if err != nil {
+		return err
+	}
+
+	stmt := `update users set first_name = $1, last_name = $2, password = $3, updated_at = $4
+		where id = $5 and email_verified_at is null and deleted_at is null`
+
+	result, err := db.ExecContext(ctx, stmt, user.FirstName, user.LastName, hashedPassword, time.Now(), user.ID)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
+
+// VerifyEmail marks the email address of a registered user as verified and activates the account
+func (u *User) VerifyEmail(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update users set email_verified_at = $1, user_active = 1, updated_at = $1
+		where id = $2 and email_verified_at is null and deleted_at is null`
+
+	result, err := db.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		return err
+	}
 
+	return expectRows(result)
+}
+
+// DeleteUnverified permanently deletes the users who registered before before and never verified their email,
+// and returns how many users were deleted
+func (u *User) DeleteUnverified(before time.Time) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `delete from users u where u.email_verified_at is null and u.created_at < $1
+		and not exists (select 1 from blogs b where b.createdby_id = u.id)`
+
+	result, err := db.ExecContext(ctx, stmt, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
 }
 
 // Insert New user into the database and return their ID
@@ -242,29 +517,45 @@ func (u *User) Insert(user User) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
+	return insertUser(ctx, db, user)
+}
+
+// insertUser is Insert on q, so a user can be created as part of a transaction
+func insertUser(ctx context.Context, q dbtx, user User) (int, error) {
 	// This generates hashed password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), 12)
 	if err != nil {
 		return 0, err
 	}
 
+	// users without a role are readers
+	if user.Role == "" {
+		user.Role = RoleReader
+	}
+
 	// variable to store the new user ID
 	var newID int
 
-	stmt := `insert into users (email, first_name, last_name, password, user_active, created_at, updated_at )
-			values ($1, $2, $3, $4 , $5, $6, $7) returning id
+	stmt := `insert into users (email, first_name, last_name, password, user_active, role, email_verified_at, created_at, updated_at )
+			values ($1, $2, $3, $4 , $5, $6, $7, $8, $9) returning id
 			`
 
-	err = db.QueryRowContext(ctx, stmt,
+	err = q.QueryRowContext(ctx, stmt,
 		user.Email,
 		user.FirstName,
 		user.LastName,
 		hashedPassword,
-		u.Active,
+		user.Active,
+		user.Role,
+		user.EmailVerifiedAt,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
 
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "users_email_lower_idx" {
+		return 0, ErrDuplicateEmail
+	}
 	if err != nil {
 		return 0, err
 	}
@@ -319,7 +610,8 @@ func (u *User) PasswordMatches(plainText string) (bool, error) {
 // User token struct
 // Token is the struct for any token in the database
 // Any json fied with - means that field will not be exported to JSON,
-// we dont want to export our TokenHashed to json
+// we dont want to export our TokenHashed to json.
+// Only TokenHash is stored, the plain text Token is only set on a token that was just generated
 type Token struct {
 	ID        int       `json:"id"`
 	UserID    int       `json:"user_id"`
@@ -329,28 +621,53 @@ type Token struct {
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
 	Expiry    time.Time `json:"expiry"`
+
+	// every token is one session of the user, these say where it is used from
+	UserAgent  string     `json:"user_agent"`
+	IP         string     `json:"ip"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+
+	// Scope is ScopeAccess or ScopeRefresh. The tokens from one login share a Family, which is the session
+	// and stays the same when the tokens are refreshed. UsedAt is set once a refresh token was swapped for new tokens
+	Scope  string     `json:"scope"`
+	Family string     `json:"family"`
+	UsedAt *time.Time `json:"-"`
 }
 
+// token scopes, only access tokens are accepted as bearer tokens
+// and only refresh tokens can be swapped for new tokens
+const (
+	ScopeAccess  = "access"
+	ScopeRefresh = "refresh"
+)
+
 // Get a User by their Token
 func (t *Token) GetByToken(plainText string) (*Token, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, user_id, email, token, token_hash, created_at, updated_at, expiry
-			from tokens where token = $1
+	// only the hash of a token is stored, so that is what we look it up by
+	query := `select id, user_id, email, token_hash, created_at, updated_at, expiry, user_agent, ip, last_used_at,
+			scope, family, used_at
+			from tokens where token_hash = $1
 			`
 
 	var token Token
-	row := db.QueryRowContext(ctx, query, plainText)
+	row := db.QueryRowContext(ctx, query, hashToken(plainText))
 	err := row.Scan(
 		&token.ID,
 		&token.UserID,
 		&token.Email,
-		&token.Token,
 		&token.TokenHash,
 		&token.CreatedAt,
 		&token.UpdatedAt,
 		&token.Expiry,
+		&token.UserAgent,
+		&token.IP,
+		&token.LastUsedAt,
+		&token.Scope,
+		&token.Family,
+		&token.UsedAt,
 	)
 
 	if err != nil {
@@ -364,13 +681,16 @@ func (t *Token) GetUserForToken(plainText string) (*User, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	query := `select id, email, first_name, last_name, password, user_active, created_at, updated_at from users where email = $1`
+	query := `select u.id, u.email, u.first_name, u.last_name, u.password, u.user_active, u.role, u.created_at, u.updated_at
+			from users u
+			inner join tokens t on (t.user_id = u.id)
+			where t.token_hash = $1 and u.deleted_at is null`
 
 	// variable for User type the function will return
 	var user User
 
 	//Query the database for only ONE row, QueryRowContext takes in 3 parameters, ctx(context.Context), query and  what we want to query by, email, name, id etc
-	row := db.QueryRowContext(ctx, query, t.UserID)
+	row := db.QueryRowContext(ctx, query, hashToken(plainText))
 
 	// scan for errors on each individual field
 	err := row.Scan(
@@ -380,6 +700,7 @@ func (t *Token) GetUserForToken(plainText string) (*User, error) {
 		&user.LastName,
 		&user.Password,
 		&user.Active,
+		&user.Role,
 		&user.CreatedAt,
 		&user.UpdatedAt,
 	)
@@ -393,6 +714,13 @@ func (t *Token) GetUserForToken(plainText string) (*User, error) {
 	return &user, nil
 }
 
+// hashToken is how a token is stored in the database, we never store the plain text
+// so somebody who gets hold of the database does not get anybody's session with it
+func hashToken(plainText string) []byte {
+	hash := sha256.Sum256([]byte(plainText))
+	return hash[:]
+}
+
 // Generate Token
 func (t *Token) GenerateToken(userID int, ttl time.Duration) (*Token, error) {
 	// we only need these two fields from our token struct to generate a user toekn
@@ -408,8 +736,7 @@ func (t *Token) GenerateToken(userID int, ttl time.Duration) (*Token, error) {
 	}
 
 	token.Token = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes)
-	hash := sha256.Sum256([]byte(token.Token))
-	token.TokenHash = hash[:]
+	token.TokenHash = hashToken(token.Token)
 
 	return token, nil
 }
@@ -418,92 +745,291 @@ func (t *Token) GenerateToken(userID int, ttl time.Duration) (*Token, error) {
 // AUthentication takes the full http request, extracts the authorization header,
 // takes the plain text token from that header and looks up the associated token entry
 // in the database, and then finds the user associated with that token. If the token
-// is valid and a user is found, the user is returned; otherwise an error is returned
-func (t *Token) AuthenticateToken(r *http.Request) (*User, error) {
+// is valid and a user is found, the user and the token (which is their session) are returned;
+// otherwise an error is returned
+func (t *Token) AuthenticateToken(r *http.Request) (*User, *Token, error) {
 	// Get the authorization header from the frontend
 	authorizationHeader := r.Header.Get("Authorization")
 	if authorizationHeader == "" {
-		return nil, errors.New("no authorization header received")
+		return nil, nil, errors.New("no authorization header received")
 	}
 
 	// Get the plain text token from the header
 	headerParts := strings.Split(authorizationHeader, " ")
 	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
-		return nil, errors.New("no valid authorization header received")
+		return nil, nil, errors.New("no valid authorization header received")
 	}
 
 	token := headerParts[1]
 
 	//make sure the token is of the correct length
 	if len(token) != 26 {
-		return nil, errors.New("token wrong size")
+		return nil, nil, errors.New("token wrong size")
 	}
 
 	// Get the token from the database, using the plaintext token to find it
 	tokn, err := t.GetByToken(token)
 	if err != nil {
-		return nil, errors.New("expired token")
+		return nil, nil, errors.New("expired token")
 	}
 
 	// ensure the tokens are not expire
 	if tokn.Expiry.Before(time.Now()) {
-		return nil, errors.New("expired token")
+		return nil, nil, errors.New("expired token")
+	}
+
+	// a refresh token lives much longer, it is only good for getting new tokens
+	if tokn.Scope != ScopeAccess {
+		return nil, nil, errors.New("not an access token")
 	}
 
 	// get the user associated with the token
 	user, err := t.GetUserForToken(token)
 	if err != nil {
-		return nil, errors.New("no matching user found")
+		return nil, nil, errors.New("no matching user found")
 	}
 
 	if user.Active == 0 {
-		return nil, errors.New("user not active")
+		return nil, nil, errors.New("user not active")
+	}
+
+	// remember when the session was last used, but don't write on every single request
+	if tokn.LastUsedAt == nil || time.Since(*tokn.LastUsedAt) > lastUsedResolution {
+		_ = t.touch(tokn.ID)
 	}
 
-	return user, nil
+	return user, tokn, nil
 }
 
 // insert token creates a token
+// a token without a scope is an access token, and one without a family is a session of its own
 func (t *Token) Insert(token Token, u User) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	// delete any existing tokens
-	stmt := `delete from tokens where user_id = $1`
-	_, err := db.ExecContext(ctx, stmt, token.UserID)
+	err := deleteExpiredTokens(ctx, db, token.UserID)
 	if err != nil {
 		return err
 	}
 
+	if token.Scope == "" {
+		token.Scope = ScopeAccess
+	}
+
+	if token.Family == "" {
+		token.Family, err = newTokenFamily()
+		if err != nil {
+			return err
+		}
+	}
+
 	token.Email = u.Email
 
-	stmt = `INSERT into tokens (user_id, email, token, token_hash, created_at,  updated_at, expiry)
-		values ($1, $2, $3, $4 , $5, $6, $7)`
+	return insertToken(ctx, db, token)
+}
+
+// deleteExpiredTokens removes the expired tokens of a user
+// a user can be logged in on more than one device, so only the expired tokens go
+func deleteExpiredTokens(ctx context.Context, q dbtx, userID int) error {
+	_, err := q.ExecContext(ctx, `delete from tokens where user_id = $1 and expiry < $2`, userID, time.Now())
+	return err
+}
 
-	_, err = db.ExecContext(ctx, stmt,
+// insertToken writes token to the tokens table
+func insertToken(ctx context.Context, q dbtx, token Token) error {
+	// the plain text token is only ever sent to the user, we keep the hash
+	stmt := `INSERT into tokens (user_id, email, token_hash, created_at,  updated_at, expiry, user_agent, ip, last_used_at,
+		scope, family)
+		values ($1, $2, $3, $4 , $5, $6, $7, $8, $9, $10, $11)`
+
+	_, err := q.ExecContext(ctx, stmt,
 		token.UserID,
 		token.Email,
-		token.Token,
 		token.TokenHash,
 		time.Now(),
 		time.Now(),
 		token.Expiry,
+		token.UserAgent,
+		token.IP,
+		time.Now(),
+		token.Scope,
+		token.Family,
 	)
+	return err
+}
+
+// newTokenFamily returns a random id for a new session
+func newTokenFamily() (string, error) {
+	randomBytes := make([]byte, 16)
+	_, err := rand.Read(randomBytes)
 	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(randomBytes), nil
+}
+
+// NewSession logs a user in: it creates a new family with an access token that lives for accessTTL
+// and a refresh token that lives for refreshTTL. userAgent and ip say where the session is used from.
+// With an accessTTL of 0 no access token is stored and nil is returned for it, for when access tokens are JWTs
+func (t *Token) NewSession(u User, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	family, err := newTokenFamily()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var access, refresh *Token
+
+	err = withTransaction(ctx, func(tx *sql.Tx) error {
+		err := deleteExpiredTokens(ctx, tx, u.ID)
+		if err != nil {
+			return err
+		}
+
+		access, refresh, err = t.issuePair(ctx, tx, u, family, accessTTL, refreshTTL, userAgent, ip)
 		return err
+	})
+	if err != nil {
+		return nil, nil, err
 	}
 
-	return nil
+	return access, refresh, nil
+}
+
+// issuePair generates and stores a new access and refresh token in family, the access token is skipped when accessTTL is 0
+func (t *Token) issuePair(ctx context.Context, q dbtx, u User, family string, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*Token, *Token, error) {
+	pair := make([]*Token, 2)
+
+	for i, p := range []struct {
+		scope string
+		ttl   time.Duration
+	}{{ScopeAccess, accessTTL}, {ScopeRefresh, refreshTTL}} {
+		if p.ttl == 0 {
+			continue
+		}
+
+		token, err := t.GenerateToken(u.ID, p.ttl)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		token.Email = u.Email
+		token.Scope = p.scope
+		token.Family = family
+		token.UserAgent = userAgent
+		token.IP = ip
+
+		err = insertToken(ctx, q, *token)
+		if err != nil {
+			return nil, nil, err
+		}
+		pair[i] = token
+	}
+
+	return pair[0], pair[1], nil
+}
+
+// Refresh swaps a refresh token for a new access and refresh token in the same family, the old refresh token
+// can't be used again. When it is used again anyway the token was stolen (or the client is broken), so the
+// whole family is deleted and ErrRefreshTokenReused is returned. The user is returned with the new tokens,
+// like with NewSession there is no new access token when accessTTL is 0
+func (t *Token) Refresh(plainText string, accessTTL, refreshTTL time.Duration, userAgent, ip string) (*User, *Token, *Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var user User
+	var access, refresh *Token
+	reused := false
+
+	err := withTransaction(ctx, func(tx *sql.Tx) error {
+		var old Token
+
+		// lock the row, so the same refresh token used twice at the same time is still caught
+		query := `select id, user_id, family, expiry, used_at from tokens
+			where token_hash = $1 and scope = $2
+			for update`
+
+		err := tx.QueryRowContext(ctx, query, hashToken(plainText), ScopeRefresh).Scan(
+			&old.ID,
+			&old.UserID,
+			&old.Family,
+			&old.Expiry,
+			&old.UsedAt,
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		// the deletion has to be committed, so this is not returned as an error here
+		if old.UsedAt != nil {
+			reused = true
+			_, err = tx.ExecContext(ctx, `delete from tokens where family = $1`, old.Family)
+			return err
+		}
+
+		if old.Expiry.Before(time.Now()) {
+			return ErrInvalidRefreshToken
+		}
+
+		query = `select id, email, first_name, last_name, user_active, role, created_at, updated_at
+			from users where id = $1 and deleted_at is null`
+
+		err = tx.QueryRowContext(ctx, query, old.UserID).Scan(
+			&user.ID,
+			&user.Email,
+			&user.FirstName,
+			&user.LastName,
+			&user.Active,
+			&user.Role,
+			&user.CreatedAt,
+			&user.UpdatedAt,
+		)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrInvalidRefreshToken
+		}
+		if err != nil {
+			return err
+		}
+
+		if user.Active == 0 {
+			return ErrInvalidRefreshToken
+		}
+
+		// the used token stays until it expires, that is how we recognise it when it comes back
+		_, err = tx.ExecContext(ctx, `update tokens set used_at = $1, updated_at = $1 where id = $2`, time.Now(), old.ID)
+		if err != nil {
+			return err
+		}
+
+		access, refresh, err = t.issuePair(ctx, tx, user, old.Family, accessTTL, refreshTTL, userAgent, ip)
+		return err
+	})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if reused {
+		return nil, nil, nil, ErrRefreshTokenReused
+	}
+
+	return &user, access, refresh, nil
 }
 
 // Delete a token
+// logging out ends the whole session, so the other tokens of its family go as well
 func (t *Token) DeleteByToken(plainText string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
 	defer cancel()
 
-	stmt := `delete from tokens where token = $1`
+	stmt := `delete from tokens where family = (select family from tokens where token_hash = $1)`
 
-	_, err := db.ExecContext(ctx, stmt, plainText)
+	_, err := db.ExecContext(ctx, stmt, hashToken(plainText))
 	if err != nil {
 		return err
 	}
@@ -537,5 +1063,97 @@ func (t *Token) ValidToken(plainText string) (bool, error) {
 		return false, errors.New("expired token")
 	}
 
+	if token.Scope != ScopeAccess {
+		return false, errors.New("not an access token")
+	}
+
 	return true, nil
 }
+
+// lastUsedResolution is how precise the last used time of a session is
+const lastUsedResolution = time.Minute
+
+// touch sets the last used time of a token to now
+func (t *Token) touch(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `update tokens set last_used_at = $1 where id = $2`, time.Now(), id)
+	return err
+}
+
+// GetAllForUser returns the sessions of a user that have not expired, the most recently used first.
+// There is one token per session (family), with the details of the newest token in it and the time the
+// session was started and last used. Only the fields shown in a list of sessions are filled in
+func (t *Token) GetAllForUser(userID int) ([]*Token, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// a used refresh token does not keep a session alive
+	query := `select family, user_id, min(created_at), max(updated_at), max(expiry),
+		(array_agg(user_agent order by created_at desc))[1], (array_agg(ip order by created_at desc))[1],
+		max(last_used_at)
+		from tokens
+		where user_id = $1 and expiry > $2 and used_at is null
+		group by family, user_id
+		order by coalesce(max(last_used_at), min(created_at)) desc`
+
+	rows, err := db.QueryContext(ctx, query, userID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var tokens []*Token
+
+	for rows.Next() {
+		var token Token
+		err := rows.Scan(
+			&token.Family,
+			&token.UserID,
+			&token.CreatedAt,
+			&token.UpdatedAt,
+			&token.Expiry,
+			&token.UserAgent,
+			&token.IP,
+			&token.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		tokens = append(tokens, &token)
+	}
+
+	return tokens, rows.Err()
+}
+
+// DeleteFamilyForUser ends one session of a user, sql.ErrNoRows is returned when the user has no session with that family
+func (t *Token) DeleteFamilyForUser(family string, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, `delete from tokens where family = $1 and user_id = $2`, family, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
+
+// DeleteOthersForUser ends every session of a user except the one of keepFamily, and returns how many sessions were ended
+func (t *Token) DeleteOthersForUser(userID int, keepFamily string) (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `with deleted as (delete from tokens where user_id = $1 and family <> $2 returning family)
+		select count(distinct family) from deleted`
+
+	var n int64
+	err := db.QueryRowContext(ctx, stmt, userID, keepFamily).Scan(&n)
+	if err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
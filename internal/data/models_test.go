@@ -1,6 +1,13 @@
 package data
 
-import "testing"
+import (
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"thelsblog-server/internal/totp"
+	"time"
+)
 
 func Test_Ping(t *testing.T) {
 	err := testDB.Ping()
@@ -18,6 +25,23 @@ func TestBlog_GetAll(t *testing.T) {
 	if len(all) != 1 {
 		t.Error("failed to get the correct number of blogs")
 	}
+
+	// the blog is of user 1
+	own, err := models.Blog.GetAllForOwner(1)
+	if err != nil {
+		t.Error("failed to get the blogs of an author", err)
+	}
+	if len(own) != 1 {
+		t.Errorf("expected the blog of user 1 but got %d blogs", len(own))
+	}
+
+	others, err := models.Blog.GetAllForOwner(2)
+	if err != nil {
+		t.Error("failed to get the blogs of an author", err)
+	}
+	if len(others) != 0 {
+		t.Errorf("expected user 2 to have no blogs but got %d", len(others))
+	}
 }
 
 func TestBlog_GetOneByID(t *testing.T) {
@@ -47,3 +71,1033 @@ func TestBlog_GetOneBySlug(t *testing.T) {
 	}
 
 }
+
+func TestBlog_UpdateByOwner(t *testing.T) {
+	b, err := models.Blog.GetOneById(1)
+	if err != nil {
+		t.Fatal("failed to get one blog by id", err)
+	}
+
+	b.Title = "Not My Blog"
+	err = b.UpdateByOwner(b.CreatedByID + 1)
+	if !errors.Is(err, ErrNotBlogOwner) {
+		t.Errorf("expected ErrNotBlogOwner updating someone else's blog but got %v", err)
+	}
+
+	err = models.Blog.DeleteByIDForOwner(1, b.CreatedByID+1)
+	if !errors.Is(err, ErrNotBlogOwner) {
+		t.Errorf("expected ErrNotBlogOwner deleting someone else's blog but got %v", err)
+	}
+
+	err = models.Blog.DeleteByIDForOwner(1000, b.CreatedByID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows deleting a blog that does not exist but got %v", err)
+	}
+}
+
+func TestBlog_CreateRollsBack(t *testing.T) {
+	// the category id does not fit in an integer column, so the last statement of Create fails
+	blog := Blog{
+		Title:       "Half Written",
+		CreatedByID: 1,
+		Content:     "never saved",
+		CategoryIDs: []int{1 << 40},
+	}
+
+	_, err := models.Blog.Create(blog)
+	if err == nil {
+		t.Fatal("expected an error creating a blog with a bad category id")
+	}
+
+	_, err = models.Blog.GetOneBySlug("half-written")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the blog to be rolled back but got %v", err)
+	}
+}
+
+func TestUser_DeleteByID(t *testing.T) {
+	userID, err := models.User.Insert(User{
+		Email:     "author@example.com",
+		FirstName: "Some",
+		LastName:  "Author",
+		Password:  "password",
+		Role:      RoleAuthor,
+	})
+	if err != nil {
+		t.Fatal("failed to insert user", err)
+	}
+
+	blogID, err := models.Blog.Create(Blog{Title: "Orphan To Be", CreatedByID: userID, Content: "mine"})
+	if err != nil {
+		t.Fatal("failed to create blog", err)
+	}
+	defer func() { _ = models.Blog.DeleteByID(blogID) }()
+
+	// deleting only moves the user to the trash, the blogs stay with them
+	err = models.User.DeleteByID(userID, 0)
+	if err != nil {
+		t.Fatal("failed to delete user", err)
+	}
+
+	_, err = models.User.GetByID(userID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a deleted user to be gone but got %v", err)
+	}
+
+	err = models.User.Purge(userID, 0)
+	if !errors.Is(err, ErrUserHasBlogs) {
+		t.Errorf("expected ErrUserHasBlogs purging a user with blogs but got %v", err)
+	}
+
+	err = models.User.Purge(userID, userID)
+	if !errors.Is(err, ErrInvalidReassignUser) {
+		t.Errorf("expected ErrInvalidReassignUser reassigning blogs to the purged user but got %v", err)
+	}
+
+	err = models.User.Purge(userID, 1)
+	if err != nil {
+		t.Fatal("failed to purge user", err)
+	}
+
+	blog, err := models.Blog.GetOneById(blogID)
+	if err != nil {
+		t.Fatal("failed to get reassigned blog", err)
+	}
+	if blog.CreatedByID != 1 {
+		t.Errorf("expected the blog to be reassigned to user 1 but it belongs to %d", blog.CreatedByID)
+	}
+}
+
+func TestBlog_Trash(t *testing.T) {
+	id, err := models.Blog.Create(Blog{Title: "Trashed Blog", CreatedByID: 1, Content: "bye"})
+	if err != nil {
+		t.Fatal("failed to create blog", err)
+	}
+
+	err = models.Blog.Purge(id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a blog that is not in the trash not to be purged but got %v", err)
+	}
+
+	err = models.Blog.DeleteByID(id)
+	if err != nil {
+		t.Fatal("failed to delete blog", err)
+	}
+
+	_, err = models.Blog.GetOneById(id)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected a trashed blog to be hidden but got %v", err)
+	}
+
+	trashed, err := models.Blog.GetTrashed()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(trashed) == 0 || trashed[0].ID != id {
+		t.Errorf("expected blog %d at the top of the trash", id)
+	}
+
+	err = models.Blog.Restore(id)
+	if err != nil {
+		t.Fatal("failed to restore blog", err)
+	}
+
+	_, err = models.Blog.GetOneById(id)
+	if err != nil {
+		t.Errorf("expected a restored blog to be back but got %v", err)
+	}
+
+	// nothing was deleted in the future, so only our blog is purged
+	_ = models.Blog.DeleteByID(id)
+	n, err := models.Blog.PurgeTrashed(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n == 0 {
+		t.Error("expected the trashed blog to be purged")
+	}
+}
+
+func TestBlog_CanTransitionTo(t *testing.T) {
+	var theTests = []struct {
+		from     string
+		to       string
+		expected bool
+	}{
+		{BlogStatusDraft, BlogStatusInReview, true},
+		{BlogStatusDraft, BlogStatusPublished, true},
+		{BlogStatusInReview, BlogStatusScheduled, true},
+		{BlogStatusScheduled, BlogStatusPublished, true},
+		{BlogStatusPublished, BlogStatusArchived, true},
+		{BlogStatusPublished, BlogStatusScheduled, false},
+		{BlogStatusArchived, BlogStatusPublished, false},
+		{BlogStatusDraft, "nonsense", false},
+	}
+
+	for _, e := range theTests {
+		b := Blog{Status: e.from}
+		if b.CanTransitionTo(e.to) != e.expected {
+			t.Errorf("expected transition from %s to %s to be %t", e.from, e.to, e.expected)
+		}
+	}
+}
+
+func TestBlog_AuthorCanTransitionTo(t *testing.T) {
+	var theTests = []struct {
+		from     string
+		to       string
+		expected bool
+	}{
+		{BlogStatusDraft, BlogStatusInReview, true},
+		{BlogStatusInReview, BlogStatusDraft, true},
+		{BlogStatusDraft, BlogStatusPublished, false},
+		{BlogStatusPublished, BlogStatusDraft, false},
+		{BlogStatusScheduled, BlogStatusDraft, false},
+		{BlogStatusArchived, BlogStatusDraft, false},
+	}
+
+	for _, e := range theTests {
+		b := Blog{Status: e.from}
+		if b.AuthorCanTransitionTo(e.to) != e.expected {
+			t.Errorf("expected an author's transition from %s to %s to be %t", e.from, e.to, e.expected)
+		}
+	}
+}
+
+func TestBlog_PublishScheduled(t *testing.T) {
+	id, err := models.Blog.Create(Blog{Title: "Scheduled Blog", CreatedByID: 1, Content: "soon"})
+	if err != nil {
+		t.Fatal("failed to create blog", err)
+	}
+
+	_, err = testDB.Exec(`update blogs set status = 'scheduled', publish_at = now() - interval '1 minute' where id = $1`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.Blog.GetPublishedBySlug("scheduled-blog")
+	if err == nil {
+		t.Error("scheduled blog should not be visible before it is published")
+	}
+
+	n, err := models.Blog.PublishScheduled()
+	if err != nil {
+		t.Error("failed to publish scheduled blogs", err)
+	}
+
+	if n != 1 {
+		t.Errorf("expected 1 blog to be published but got %d", n)
+	}
+
+	_, err = models.Blog.GetPublishedBySlug("scheduled-blog")
+	if err != nil {
+		t.Error("scheduled blog should be visible once it is published", err)
+	}
+
+	_ = models.Blog.DeleteByID(id)
+}
+
+func TestBlogRevision_GetAllForBlog(t *testing.T) {
+	id, err := models.Blog.Create(Blog{Title: "Revised Blog", CreatedByID: 1, Content: "first"})
+	if err != nil {
+		t.Fatal("failed to create blog", err)
+	}
+
+	b, err := models.Blog.GetOneById(id)
+	if err != nil {
+		t.Fatal("failed to get blog", err)
+	}
+
+	b.Content = "second"
+	err = b.Update()
+	if err != nil {
+		t.Fatal("failed to update blog", err)
+	}
+
+	revisions, err := models.BlogRevision.GetAllForBlog(id)
+	if err != nil {
+		t.Fatal("failed to get revisions", err)
+	}
+
+	if len(revisions) != 2 {
+		t.Fatalf("expected 2 revisions but got %d", len(revisions))
+	}
+
+	first, err := models.BlogRevision.GetByID(revisions[1].ID)
+	if err != nil {
+		t.Fatal("failed to get revision", err)
+	}
+
+	if first.Revision != 1 || first.Content != "first" {
+		t.Errorf("expected revision 1 with the original content but got revision %d with %q", first.Revision, first.Content)
+	}
+
+	_ = models.Blog.DeleteByID(id)
+}
+
+func TestCategory_GetAll(t *testing.T) {
+	all, err := models.Category.GetAll()
+	if err != nil {
+		t.Fatal("failed to get all categories", err)
+	}
+
+	if len(all) != 7 {
+		t.Errorf("expected 7 categories but got %d", len(all))
+	}
+
+	for _, c := range all {
+		// the test blog is published and in Romance
+		if c.CategoryName == "Romance" && c.PostCount != 1 {
+			t.Errorf("expected Romance to have 1 post but got %d", c.PostCount)
+		}
+	}
+}
+
+func TestCategory_Update(t *testing.T) {
+	missing := Category{ID: 1000, CategoryName: "Nothing"}
+	err := missing.Update()
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a category that does not exist but got %v", err)
+	}
+}
+
+func TestCategory_DeleteByID(t *testing.T) {
+	id, err := models.Category.Insert(Category{CategoryName: "Poetry"})
+	if err != nil {
+		t.Fatal("failed to insert category", err)
+	}
+
+	_, err = testDB.Exec(`insert into blogs_categorys (blog_id, category_id, created_at, updated_at) values (1, $1, now(), now())`, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Category.DeleteByID(id, false)
+	if !errors.Is(err, ErrCategoryInUse) {
+		t.Errorf("expected ErrCategoryInUse but got %v", err)
+	}
+
+	err = models.Category.DeleteByID(id, true)
+	if err != nil {
+		t.Error("failed to cascade delete category", err)
+	}
+
+	err = models.Category.DeleteByID(id, false)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected sql.ErrNoRows for a deleted category but got %v", err)
+	}
+}
+
+func TestBlog_GetPublishedByCategory(t *testing.T) {
+	blogs, metadata, err := models.Blog.GetPublishedByCategory("romance", Pagination{Page: 1, PageSize: 10})
+	if err != nil {
+		t.Fatal("failed to get blogs by category", err)
+	}
+
+	if len(blogs) != 1 || metadata.TotalRecords != 1 {
+		t.Errorf("expected 1 blog in romance but got %d", len(blogs))
+	}
+
+	blogs, _, err = models.Blog.GetPublishedByAuthor(1000, Pagination{Page: 1, PageSize: 10})
+	if err != nil {
+		t.Fatal("failed to get blogs by author", err)
+	}
+
+	if len(blogs) != 0 {
+		t.Errorf("expected no blogs for an author without any but got %d", len(blogs))
+	}
+}
+
+func TestBlog_GetAllPaginated(t *testing.T) {
+	blogs, metadata, err := models.Blog.GetAllPaginated(BlogFilters{
+		Pagination:    Pagination{Page: 1, PageSize: 10},
+		Sort:          "title",
+		Order:         "asc",
+		PublishedOnly: true,
+	})
+	if err != nil {
+		t.Fatal("failed to get paginated blogs", err)
+	}
+
+	if len(blogs) != 1 || metadata.TotalRecords != 1 || metadata.LastPage != 1 {
+		t.Errorf("expected 1 blog on 1 page but got %d blogs and metadata %+v", len(blogs), metadata)
+	}
+
+	from := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	blogs, _, err = models.Blog.GetAllPaginated(BlogFilters{
+		Pagination:    Pagination{Page: 1, PageSize: 10},
+		From:          &from,
+		PublishedOnly: true,
+	})
+	if err != nil {
+		t.Fatal("failed to get paginated blogs", err)
+	}
+
+	if len(blogs) != 0 {
+		t.Errorf("expected no blogs created after %s but got %d", from, len(blogs))
+	}
+}
+
+func TestBlog_GetFeed(t *testing.T) {
+	var ids []int
+	for _, title := range []string{"Feed One", "Feed Two", "Feed Three"} {
+		id, err := models.Blog.Create(Blog{Title: title, CreatedByID: 1})
+		if err != nil {
+			t.Fatal("failed to create blog", err)
+		}
+		ids = append(ids, id)
+	}
+
+	filters := BlogFilters{AuthorID: 1}
+
+	first, page, err := models.Blog.GetFeed(filters, nil, 2)
+	if err != nil {
+		t.Fatal("failed to get feed", err)
+	}
+
+	if len(first) != 2 || page.NextCursor == "" || page.PrevCursor != "" {
+		t.Fatalf("expected 2 blogs and only a next cursor but got %d blogs and %+v", len(first), page)
+	}
+
+	next, _ := DecodeCursor(page.NextCursor)
+	second, page, err := models.Blog.GetFeed(filters, next, 2)
+	if err != nil {
+		t.Fatal("failed to get second page of feed", err)
+	}
+
+	// the three new blogs plus the test blog
+	if len(second) != 2 || page.NextCursor != "" || page.PrevCursor == "" {
+		t.Fatalf("expected 2 blogs and only a prev cursor but got %d blogs and %+v", len(second), page)
+	}
+
+	prev, _ := DecodeCursor(page.PrevCursor)
+	back, _, err := models.Blog.GetFeed(filters, prev, 2)
+	if err != nil {
+		t.Fatal("failed to go back in feed", err)
+	}
+
+	if len(back) != 2 || back[0].ID != first[0].ID || back[1].ID != first[1].ID {
+		t.Error("going back did not return the first page")
+	}
+
+	for _, id := range ids {
+		_ = models.Blog.DeleteByID(id)
+	}
+}
+
+func TestBlog_GetFeed_published(t *testing.T) {
+	// the public feed is in the order the blogs were published, not created
+	var blogs []*Blog
+	for _, title := range []string{"Written First", "Written Second"} {
+		id, err := models.Blog.Create(Blog{Title: title, CreatedByID: 1})
+		if err != nil {
+			t.Fatal("failed to create blog", err)
+		}
+		blogs = append(blogs, &Blog{ID: id, Status: BlogStatusDraft})
+	}
+
+	for _, i := range []int{1, 0} {
+		if err := blogs[i].SetStatus(BlogStatusPublished, nil); err != nil {
+			t.Fatal("failed to publish blog", err)
+		}
+	}
+
+	feed, page, err := models.Blog.GetFeed(BlogFilters{AuthorID: 1, PublishedOnly: true}, nil, 1)
+	if err != nil {
+		t.Fatal("failed to get feed", err)
+	}
+
+	if len(feed) != 1 || feed[0].ID != blogs[0].ID {
+		t.Fatal("expected the blog published last to come first")
+	}
+
+	next, _ := DecodeCursor(page.NextCursor)
+	if next == nil || !next.Time.Equal(*feed[0].PublishedAt) {
+		t.Error("expected the cursor to point at when the blog was published")
+	}
+
+	for _, blog := range blogs {
+		_ = models.Blog.DeleteByID(blog.ID)
+	}
+}
+
+func TestBlog_Search(t *testing.T) {
+	filters := BlogFilters{Pagination: Pagination{Page: 1, PageSize: 10}, PublishedOnly: true}
+
+	results, metadata, err := models.Blog.Search("yolo", filters)
+	if err != nil {
+		t.Fatal("failed to search blogs", err)
+	}
+
+	if len(results) != 1 || metadata.TotalRecords != 1 {
+		t.Fatalf("expected 1 result but got %d", len(results))
+	}
+
+	if results[0].Snippet != "<mark>yolo</mark> content" {
+		t.Errorf("expected a highlighted snippet but got %q", results[0].Snippet)
+	}
+
+	results, _, err = models.Blog.Search("spaceships", filters)
+	if err != nil {
+		t.Fatal("failed to search blogs", err)
+	}
+
+	if len(results) != 0 {
+		t.Errorf("expected no results but got %d", len(results))
+	}
+}
+
+func TestOneTimeToken_Consume(t *testing.T) {
+	token, err := models.OneTimeToken.New(1, PurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal("failed to create one time token", err)
+	}
+
+	_, err = models.OneTimeToken.Consume(token.PlainText, "something else")
+	if !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Errorf("expected a token to only work for its purpose but got %v", err)
+	}
+
+	userID, err := models.OneTimeToken.Consume(token.PlainText, PurposePasswordReset)
+	if err != nil {
+		t.Fatal("failed to consume token", err)
+	}
+	if userID != 1 {
+		t.Errorf("expected the token of user 1 but got %d", userID)
+	}
+
+	_, err = models.OneTimeToken.Consume(token.PlainText, PurposePasswordReset)
+	if !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Errorf("expected a token to only work once but got %v", err)
+	}
+
+	expired, err := models.OneTimeToken.New(1, PurposePasswordReset, -time.Minute)
+	if err != nil {
+		t.Fatal("failed to create one time token", err)
+	}
+
+	_, err = models.OneTimeToken.Consume(expired.PlainText, PurposePasswordReset)
+	if !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Errorf("expected an expired token not to work but got %v", err)
+	}
+}
+
+func TestUser_VerifyEmail(t *testing.T) {
+	userID, err := models.User.Insert(User{
+		Email:     "Reader@Example.com",
+		FirstName: "Some",
+		LastName:  "Reader",
+		Password:  "password",
+	})
+	if err != nil {
+		t.Fatal("failed to insert user", err)
+	}
+
+	exists, err := models.User.EmailExists("reader@example.com")
+	if err != nil || !exists {
+		t.Errorf("expected the email address to exist whatever the case but got %v, %v", exists, err)
+	}
+
+	err = models.User.VerifyEmail(userID)
+	if err != nil {
+		t.Fatal("failed to verify email", err)
+	}
+
+	user, err := models.User.GetByID(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Active != 1 {
+		t.Error("expected a verified user to be active")
+	}
+
+	err = models.User.VerifyEmail(userID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected verifying twice to fail but got %v", err)
+	}
+
+	// only users who never verified are deleted
+	unverifiedID, err := models.User.Insert(User{Email: "late@example.com", FirstName: "Too", LastName: "Late", Password: "password"})
+	if err != nil {
+		t.Fatal("failed to insert user", err)
+	}
+
+	_, err = models.User.DeleteUnverified(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.User.GetByID(unverifiedID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected the unverified user to be deleted but got %v", err)
+	}
+
+	_, err = models.User.GetByID(userID)
+	if err != nil {
+		t.Errorf("expected the verified user to stay but got %v", err)
+	}
+}
+
+func TestToken_StoredHashed(t *testing.T) {
+	user, err := models.User.GetByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := models.Token.GenerateToken(user.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = models.Token.Insert(*token, *user)
+	if err != nil {
+		t.Fatal("failed to insert token", err)
+	}
+
+	// the plain text is nowhere in the tokens table
+	var found bool
+	err = testDB.QueryRow(`select exists(select 1 from tokens t where t::text like '%' || $1 || '%')`, token.Token).Scan(&found)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found {
+		t.Error("expected only the hash of the token to be stored")
+	}
+
+	valid, err := models.Token.ValidToken(token.Token)
+	if err != nil || !valid {
+		t.Errorf("expected the token to be valid but got %v", err)
+	}
+
+	err = models.Token.DeleteByToken(token.Token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid, _ = models.Token.ValidToken(token.Token)
+	if valid {
+		t.Error("expected a deleted token not to be valid")
+	}
+}
+
+func TestToken_Sessions(t *testing.T) {
+	user, err := models.User.GetByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// logging in twice gives two sessions that both work
+	var tokens []*Token
+	for _, agent := range []string{"laptop", "phone"} {
+		token, _, err := models.Token.NewSession(*user, time.Hour, 2*time.Hour, agent, "127.0.0.1")
+		if err != nil {
+			t.Fatal("failed to start session", err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	for _, token := range tokens {
+		valid, _ := models.Token.ValidToken(token.Token)
+		if !valid {
+			t.Errorf("expected the %s session to still be valid", token.UserAgent)
+		}
+	}
+
+	sessions, err := models.Token.GetAllForUser(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) < 2 {
+		t.Fatalf("expected at least 2 sessions but got %d", len(sessions))
+	}
+
+	phone := tokens[1]
+
+	err = models.Token.DeleteFamilyForUser(phone.Family, user.ID+1)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected not to revoke a session of someone else but got %v", err)
+	}
+
+	_, err = models.Token.DeleteOthersForUser(user.ID, phone.Family)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sessions, _ = models.Token.GetAllForUser(user.ID)
+	if len(sessions) != 1 || sessions[0].Family != phone.Family {
+		t.Errorf("expected only the phone session to be left but got %d sessions", len(sessions))
+	}
+
+	_ = models.Token.DeleteTokensForUser(user.ID)
+}
+
+func TestToken_Refresh(t *testing.T) {
+	user, err := models.User.GetByID(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access, refresh, err := models.Token.NewSession(*user, time.Hour, 2*time.Hour, "laptop", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a refresh token is no bearer token
+	valid, _ := models.Token.ValidToken(refresh.Token)
+	if valid {
+		t.Error("expected a refresh token not to be accepted as an access token")
+	}
+
+	_, newAccess, newRefresh, err := models.Token.Refresh(refresh.Token, time.Hour, 2*time.Hour, "laptop", "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newAccess.Family != access.Family || newRefresh.Family != access.Family {
+		t.Error("expected the new tokens to stay in the same family")
+	}
+
+	valid, _ = models.Token.ValidToken(newAccess.Token)
+	if !valid {
+		t.Error("expected the new access token to be valid")
+	}
+
+	// using the old refresh token again revokes the whole family
+	_, _, _, err = models.Token.Refresh(refresh.Token, time.Hour, 2*time.Hour, "laptop", "127.0.0.1")
+	if !errors.Is(err, ErrRefreshTokenReused) {
+		t.Fatalf("expected ErrRefreshTokenReused but got %v", err)
+	}
+
+	valid, _ = models.Token.ValidToken(newAccess.Token)
+	if valid {
+		t.Error("expected the access token of a revoked family to be invalid")
+	}
+
+	_, _, _, err = models.Token.Refresh(newRefresh.Token, time.Hour, 2*time.Hour, "laptop", "127.0.0.1")
+	if !errors.Is(err, ErrInvalidRefreshToken) {
+		t.Errorf("expected ErrInvalidRefreshToken for a revoked family but got %v", err)
+	}
+}
+
+func TestUser_TwoFactor(t *testing.T) {
+	userID, err := models.User.Insert(User{
+		Email:     "twofactor@example.com",
+		FirstName: "Two",
+		LastName:  "Factor",
+		Password:  "verysecret",
+		Active:    1,
+		Role:      RoleAdmin,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	secret, err := models.User.StartTOTP(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// not on until it is confirmed
+	err = models.User.CheckTwoFactor(userID, "123456")
+	if !errors.Is(err, ErrTwoFactorNotEnabled) {
+		t.Errorf("expected ErrTwoFactorNotEnabled before confirming but got %v", err)
+	}
+
+	_, err = models.User.ConfirmTOTP(userID, "000000")
+	if !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("expected a wrong code not to confirm but got %v", err)
+	}
+
+	code, _ := totp.Code(secret, totp.Step(time.Now()))
+	codes, err := models.User.ConfirmTOTP(userID, code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(codes) != recoveryCodeCount {
+		t.Fatalf("expected %d recovery codes but got %d", recoveryCodeCount, len(codes))
+	}
+
+	// the code used to confirm can't be used again
+	err = models.User.CheckTwoFactor(userID, code)
+	if !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("expected a used code to be refused but got %v", err)
+	}
+
+	next, _ := totp.Code(secret, totp.Step(time.Now())+1)
+	err = models.User.CheckTwoFactor(userID, next)
+	if err != nil {
+		t.Errorf("expected the next code to work but got %v", err)
+	}
+
+	// a recovery code works once, typed in any case
+	err = models.User.CheckTwoFactor(userID, strings.ToUpper(codes[0]))
+	if err != nil {
+		t.Errorf("expected the recovery code to work but got %v", err)
+	}
+	err = models.User.CheckTwoFactor(userID, codes[0])
+	if !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("expected a used recovery code to be refused but got %v", err)
+	}
+
+	status, err := models.User.GetTwoFactor(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.Enabled || status.RecoveryCodesLeft != recoveryCodeCount-1 {
+		t.Errorf("expected two factor on with %d codes left but got %+v", recoveryCodeCount-1, status)
+	}
+
+	err = models.User.DisableTOTP(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, _ = models.User.GetTwoFactor(userID)
+	if status.Enabled || status.RecoveryCodesLeft != 0 {
+		t.Errorf("expected two factor off without codes but got %+v", status)
+	}
+}
+
+func TestWebAuthnCredential(t *testing.T) {
+	credentialID := []byte{1, 2, 3, 4}
+
+	id, err := models.WebAuthnCredential.Insert(WebAuthnCredential{
+		UserID:       1,
+		CredentialID: credentialID,
+		PublicKey:    []byte{5, 6, 7},
+		Name:         "laptop",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := models.WebAuthnCredential.GetByCredentialID(credentialID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if credential.ID != id || credential.UserID != 1 || credential.Name != "laptop" {
+		t.Errorf("got the wrong credential back: %+v", credential)
+	}
+
+	err = models.WebAuthnCredential.Used(id, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentials, err := models.WebAuthnCredential.GetAllForUser(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(credentials) != 1 || credentials[0].SignCount != 5 || credentials[0].LastUsedAt == nil {
+		t.Errorf("expected one used credential with sign count 5 but got %d", len(credentials))
+	}
+
+	// the same authenticator can't be registered twice
+	_, err = models.WebAuthnCredential.Insert(WebAuthnCredential{UserID: 1, CredentialID: credentialID, PublicKey: []byte{5}})
+	if err == nil {
+		t.Error("expected a duplicate credential id to fail")
+	}
+
+	err = models.WebAuthnCredential.DeleteForUser(id, 2)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Errorf("expected not to delete a credential of someone else but got %v", err)
+	}
+
+	err = models.WebAuthnCredential.DeleteForUser(id, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestUserIdentity_LinkOrProvision(t *testing.T) {
+	external := ExternalUser{
+		Issuer:        "https://id.example.com",
+		Subject:       "1234",
+		Email:         "sso@example.com",
+		EmailVerified: true,
+		FirstName:     "Single",
+		LastName:      "Sign",
+	}
+
+	// somebody new gets an account
+	user, err := models.UserIdentity.LinkOrProvision(external, "reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.Email != external.Email || user.Role != "reader" || user.Active != 1 {
+		t.Errorf("got the wrong user provisioned: %+v", user)
+	}
+
+	// and the same account the next time, even when the email address changed
+	external.Email = "changed@example.com"
+	again, err := models.UserIdentity.LinkOrProvision(external, "reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.ID != user.ID {
+		t.Errorf("expected user %d again but got %d", user.ID, again.ID)
+	}
+
+	// an existing user is linked by their email address
+	admin, err := models.UserIdentity.LinkOrProvision(ExternalUser{Issuer: external.Issuer, Subject: "5678",
+		Email: "Admin@Example.com", EmailVerified: true}, "reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admin.ID != 1 || admin.Role != "admin" {
+		t.Errorf("expected to be linked to the admin but got %+v", admin)
+	}
+
+	// unless the provider didn't verify it
+	_, err = models.UserIdentity.LinkOrProvision(ExternalUser{Issuer: external.Issuer, Subject: "9999",
+		Email: "admin@example.com"}, "reader")
+	if !errors.Is(err, ErrExternalEmailNotVerified) {
+		t.Errorf("expected ErrExternalEmailNotVerified but got %v", err)
+	}
+}
+
+func TestLoginPolicy_Delay(t *testing.T) {
+	policy := LoginPolicy{FreeAttempts: 3, BaseDelay: time.Second, MaxFailures: 10, Lockout: 15 * time.Minute}
+
+	var theTests = []struct {
+		failures int
+		expected time.Duration
+	}{
+		{1, 0},
+		{3, 0},
+		{4, time.Second},
+		{5, 2 * time.Second},
+		{9, 32 * time.Second},
+		{10, 15 * time.Minute},
+		{11, 15 * time.Minute},
+	}
+
+	for _, e := range theTests {
+		if got := policy.Delay(e.failures); got != e.expected {
+			t.Errorf("%d failures: expected a delay of %s but got %s", e.failures, e.expected, got)
+		}
+	}
+
+	// the backoff is never longer than a lockout
+	policy.Lockout = 5 * time.Second
+	if got := policy.Delay(9); got != 5*time.Second {
+		t.Errorf("expected the backoff to stop at the lockout but got %s", got)
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	policy := LoginPolicy{FreeAttempts: 1, BaseDelay: time.Minute, MaxFailures: 3, Lockout: time.Hour, ForgetAfter: time.Hour}
+	account, ip := AccountKey(" Throttled@Example.com"), IPKey("192.0.2.1")
+
+	throttle, err := models.LoginThrottle.Failed(account, policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if throttle.Failures != 1 || throttle.BlockedUntil != nil {
+		t.Errorf("expected the first failure to be free but got %+v", throttle)
+	}
+
+	wait, err := models.LoginThrottle.Blocked(account, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Errorf("expected not to wait but got %s", wait)
+	}
+
+	// the third failure locks the account
+	for i := 0; i < 2; i++ {
+		throttle, err = models.LoginThrottle.Failed(account, policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if throttle.Failures != 3 {
+		t.Errorf("expected 3 failures but got %d", throttle.Failures)
+	}
+
+	wait, err = models.LoginThrottle.Blocked(AccountKey("throttled@example.com"), ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait < 59*time.Minute || wait > time.Hour {
+		t.Errorf("expected to wait about an hour but got %s", wait)
+	}
+
+	// the ip address on its own is fine
+	wait, err = models.LoginThrottle.Blocked(ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Errorf("expected the ip address not to wait but got %s", wait)
+	}
+
+	// until an admin unlocks it
+	err = models.LoginThrottle.Reset(account)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wait, err = models.LoginThrottle.Blocked(account, ip)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wait != 0 {
+		t.Errorf("expected the account to be unlocked but it is blocked for %s", wait)
+	}
+}
+
+func TestOneTimeToken_concurrent(t *testing.T) {
+	// a new password reset link replaces the old one
+	first, err := models.OneTimeToken.New(1, PurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = models.OneTimeToken.New(1, PurposePasswordReset, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.OneTimeToken.Consume(first.PlainText, PurposePasswordReset)
+	if !errors.Is(err, ErrInvalidOneTimeToken) {
+		t.Errorf("expected the older reset token to be replaced but got %v", err)
+	}
+
+	// but passkey login challenges of the same user work side by side
+	first, err = models.OneTimeToken.New(1, PurposeWebAuthnLogin, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := models.OneTimeToken.New(1, PurposeWebAuthnLogin, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, token := range []*OneTimeToken{first, second} {
+		_, err = models.OneTimeToken.Consume(token.PlainText, PurposeWebAuthnLogin)
+		if err != nil {
+			t.Errorf("expected both passkey challenges to work but got %v", err)
+		}
+	}
+}
+
+func TestUser_duplicateEmail(t *testing.T) {
+	id, err := models.User.Insert(User{Email: "Twice@Example.com", FirstName: "Only", LastName: "Once", Password: "password"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = models.User.Insert(User{Email: "twice@example.com", FirstName: "Only", LastName: "Once", Password: "password"})
+	if !errors.Is(err, ErrDuplicateEmail) {
+		t.Errorf("expected ErrDuplicateEmail but got %v", err)
+	}
+
+	// logging in doesn't care about case either
+	user, err := models.User.GetByEmail("TWICE@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != id {
+		t.Errorf("expected user %d but got %d", id, user.ID)
+	}
+}
@@ -0,0 +1,133 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"time"
+)
+
+// What a one time token can be used for, a token only works for the purpose it was made for
+const (
+	PurposePasswordReset     = "password_reset"
+	PurposeEmailVerification = "email_verification"
+	// a login challenge is what Login hands out instead of a session when the user has two factor authentication
+	PurposeLoginChallenge = "login_challenge"
+	// the challenges of passkey ceremonies, the plain text of the token is the challenge
+	PurposeWebAuthnRegistration = "webauthn_registration"
+	PurposeWebAuthnLogin        = "webauthn_login"
+	// after logging in with an identity provider the front end gets one of these to swap for a session
+	PurposeExternalLogin = "external_login"
+)
+
+// concurrentPurposes are the purposes a user can have more tokens for at the same time, each one works until it
+// expires. Anybody can start a passkey login for any email address, if that replaced the older challenge
+// somebody could keep breaking the login of someone else, or of the same user on another device
+var concurrentPurposes = map[string]bool{
+	PurposeWebAuthnLogin: true,
+}
+
+// ErrInvalidOneTimeToken is returned when a one time token does not exist, has expired or was already used
+var ErrInvalidOneTimeToken = errors.New("invalid or expired token")
+
+// OneTimeToken is a token we email to a user, it can be used once before it expires.
+// Only the hash is stored, PlainText is only set on a token that was just created
+type OneTimeToken struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"user_id"`
+	Purpose   string     `json:"purpose"`
+	PlainText string     `json:"-"`
+	TokenHash []byte     `json:"-"`
+	Expiry    time.Time  `json:"expiry"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// New creates and saves a token for userID that can be used for purpose until ttl has passed.
+// Older unused tokens of the user for the same purpose stop working, only the newest link does,
+// except for the concurrentPurposes where only the expired ones are cleaned up
+func (o *OneTimeToken) New(userID int, purpose string, ttl time.Duration) (*OneTimeToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	plainText, err := RandomPlainText()
+	if err != nil {
+		return nil, err
+	}
+
+	token := &OneTimeToken{
+		UserID:    userID,
+		Purpose:   purpose,
+		PlainText: plainText,
+		Expiry:    time.Now().Add(ttl),
+		CreatedAt: time.Now(),
+	}
+	token.TokenHash = hashToken(token.PlainText)
+
+	err = withTransaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		if concurrentPurposes[purpose] {
+			_, err = tx.ExecContext(ctx, `delete from one_time_tokens where user_id = $1 and purpose = $2 and expiry <= $3`,
+				userID, purpose, token.CreatedAt)
+		} else {
+			_, err = tx.ExecContext(ctx, `delete from one_time_tokens where user_id = $1 and purpose = $2 and used_at is null`,
+				userID, purpose)
+		}
+		if err != nil {
+			return err
+		}
+
+		stmt := `insert into one_time_tokens (user_id, purpose, token_hash, expiry, created_at)
+			values ($1, $2, $3, $4, $5) returning id`
+
+		return tx.QueryRowContext(ctx, stmt,
+			token.UserID,
+			token.Purpose,
+			token.TokenHash,
+			token.Expiry,
+			token.CreatedAt,
+		).Scan(&token.ID)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return token, nil
+}
+
+// RandomPlainText returns a random string that looks like the plain text of a one time token,
+// for when we have to answer somebody without letting on that there is no token for them
+func RandomPlainText() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes), nil
+}
+
+// Consume marks the token as used and returns the id of its user. Marking it is one statement,
+// so two requests with the same token can't both get through. It returns ErrInvalidOneTimeToken
+// when the token is unknown, made for something else, expired or already used
+func (o *OneTimeToken) Consume(plainText, purpose string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `update one_time_tokens set used_at = $1
+		where token_hash = $2 and purpose = $3 and used_at is null and expiry > $1
+		returning user_id`
+
+	var userID int
+	err := db.QueryRowContext(ctx, stmt, time.Now(), hashToken(plainText), purpose).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, ErrInvalidOneTimeToken
+		}
+		return 0, err
+	}
+
+	return userID, nil
+}
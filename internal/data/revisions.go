@@ -0,0 +1,127 @@
+package data
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// BlogRevision is an immutable copy of a blog, written every time the blog is saved
+type BlogRevision struct {
+	ID          int       `json:"id"`
+	BlogID      int       `json:"blog_id"`
+	Revision    int       `json:"revision"`
+	Title       string    `json:"title"`
+	Description string    `json:"description,omitempty"`
+	Content     string    `json:"content,omitempty"`
+	AuthorID    int       `json:"author_id"`
+	Author      User      `json:"author"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// insertRevision writes a new revision of blog, saved by authorID
+// the revision number is one more than the last revision of that blog
+func insertRevision(ctx context.Context, q dbtx, blog Blog, authorID int) error {
+	stmt := `insert into blog_revisions (blog_id, revision, title, description, content, author_id, created_at)
+		values ($1, (select coalesce(max(revision), 0) + 1 from blog_revisions where blog_id = $1), $2, $3, $4, $5, $6)`
+
+	_, err := q.ExecContext(ctx, stmt,
+		blog.ID,
+		blog.Title,
+		blog.Description,
+		blog.Content,
+		authorID,
+		time.Now(),
+	)
+	if err != nil {
+		return fmt.Errorf("writing revision: %s", err.Error())
+	}
+
+	return nil
+}
+
+// GetAllForBlog returns every revision of a blog, newest first
+// the content is left out, use GetByID to get a full revision
+func (r *BlogRevision) GetAllForBlog(blogID int) ([]*BlogRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	// the author of a revision is gone once that user is deleted, the revision stays
+	query := `select r.id, r.blog_id, r.revision, r.title, coalesce(r.author_id, 0), r.created_at,
+		coalesce(u.id, 0), coalesce(u.first_name, '')
+		from blog_revisions r
+		left join users u on (r.author_id = u.id)
+		where r.blog_id = $1
+		order by r.revision desc`
+
+	rows, err := db.QueryContext(ctx, query, blogID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []*BlogRevision
+
+	for rows.Next() {
+		var revision BlogRevision
+		err := rows.Scan(
+			&revision.ID,
+			&revision.BlogID,
+			&revision.Revision,
+			&revision.Title,
+			&revision.AuthorID,
+			&revision.CreatedAt,
+			&revision.Author.ID,
+			&revision.Author.FirstName,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		revisions = append(revisions, &revision)
+	}
+
+	return revisions, nil
+}
+
+// GetByID returns one full revision by its id
+func (r *BlogRevision) GetByID(id int) (*BlogRevision, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select r.id, r.blog_id, r.revision, r.title, r.description, r.content, coalesce(r.author_id, 0), r.created_at,
+		coalesce(u.id, 0), coalesce(u.first_name, '')
+		from blog_revisions r
+		left join users u on (r.author_id = u.id)
+		where r.id = $1`
+
+	var revision BlogRevision
+
+	err := db.QueryRowContext(ctx, query, id).Scan(
+		&revision.ID,
+		&revision.BlogID,
+		&revision.Revision,
+		&revision.Title,
+		&revision.Description,
+		&revision.Content,
+		&revision.AuthorID,
+		&revision.CreatedAt,
+		&revision.Author.ID,
+		&revision.Author.FirstName,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &revision, nil
+}
+
+// Document returns the revision as one text, which is what we diff revisions on
+func (r *BlogRevision) Document() string {
+	return fmt.Sprintf("Title: %s\nDescription: %s\n\n%s\n", r.Title, r.Description, r.Content)
+}
+
+// Label is how the revision is named in the header of a diff
+func (r *BlogRevision) Label() string {
+	return fmt.Sprintf("revision %d\t%s", r.Revision, r.CreatedAt.Format(time.RFC3339))
+}
@@ -76,14 +76,15 @@ func TestMain(m *testing.M) {
 	// get our models
 	models = New(testDB)
 
-	err = createTables(testDB)
+	// the test database gets its tables from the same migrations as production
+	_, err = MigrateUp(testDB)
 	if err != nil {
-		log.Fatalf("could not create tables: %v", err)
+		log.Fatalf("could not migrate the database: %v", err)
 	}
 
 	err = insertData(testDB)
 	if err != nil {
-		log.Fatalf("could not create tables: %v", err)
+		log.Fatalf("could not insert data: %v", err)
 	}
 
 	code := m.Run()
@@ -95,196 +96,48 @@ func TestMain(m *testing.M) {
 	os.Exit(code)
 }
 
-// createTables will create all the tables in our test database, duplicating the structure
-// of the production environment
-func createTables(db *sql.DB) error {
-	stmt := `
-
-
---
--- Name: blogs; Type: TABLE; Schema: public; Owner: -
---
-CREATE TABLE
-  public.blogs (
-    id integer NOT NULL GENERATED ALWAYS AS IDENTITY,
-    title character varying(512) NULL,
-    created_at timestamp without time zone NULL,
-    updated_at timestamp without time zone NULL,
-    slug character varying(512) NULL,
-    description text NULL,
-    created_by character varying(255) NULL,
-    content text NULL,
-    createdby_id integer NOT NULL
-  );
-
-ALTER TABLE
-  public.blogs
-ADD
-  CONSTRAINT blogs_pkey PRIMARY KEY (id)
-
---
--- Name: blogs_categorys; Type: TABLE; Schema: public; Owner: -
---
-
-CREATE TABLE
-  public.blogs_categorys (
-    id integer NOT NULL GENERATED ALWAYS AS IDENTITY,
-    blog_id integer NULL,
-    category_id integer NULL,
-    created_at timestamp without time zone NULL,
-    updated_at timestamp without time zone NULL
-  );
-
-ALTER TABLE
-  public.blogs_categorys
-ADD
-  CONSTRAINT blogs_categorys_pkey PRIMARY KEY (id)
-
-
---
--- Name: blogs_categorys_id_seq; Type: SEQUENCE; Schema: public; Owner: -
---
-
-ALTER TABLE public.blogs_categorys ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
-    SEQUENCE NAME public.blogs_categorys_id_seq
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1
-);
-
-
---
--- Name: blogs_id_seq; Type: SEQUENCE; Schema: public; Owner: -
---
-
-ALTER TABLE public.blogs ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
-    SEQUENCE NAME public.blogs_id_seq
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1
-);
-
-
---
--- Name: categorys; Type: TABLE; Schema: public; Owner: -
---
-
-CREATE TABLE public.categorys (
-    id integer NOT NULL,
-    category_name character varying(255),
-    created_at timestamp without time zone,
-    updated_at timestamp without time zone
-);
-
-
---
--- Name: categorys_id_seq; Type: SEQUENCE; Schema: public; Owner: -
---
-
-ALTER TABLE public.categorys ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
-    SEQUENCE NAME public.categorys_id_seq
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1
-);
-
-
---
--- Name: tokens; Type: TABLE; Schema: public; Owner: -
---
-
-CREATE TABLE public.tokens (
-    id integer NOT NULL,
-    user_id integer,
-    email character varying(255) NOT NULL,
-    token character varying(255) NOT NULL,
-    token_hash bytea NOT NULL,
-    expiry timestamp with time zone NOT NULL,
-    created_at timestamp without time zone NOT NULL,
-    updated_at timestamp without time zone NOT NULL
-);
-
-
---
--- Name: tokens_id_seq; Type: SEQUENCE; Schema: public; Owner: -
---
-
-ALTER TABLE public.tokens ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
-    SEQUENCE NAME public.tokens_id_seq
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1
-);
-
-
---
--- Name: users; Type: TABLE; Schema: public; Owner: -
---
-
-CREATE TABLE public.users (
-    id integer NOT NULL,
-    email character varying(255),
-    first_name character varying(255) NOT NULL,
-    last_name character varying(255) NOT NULL,
-    password character varying(60) NOT NULL,
-    created_at timestamp without time zone NOT NULL,
-    updated_at timestamp without time zone NOT NULL,
-    user_active integer DEFAULT 0
-);
-
-
---
--- Name: users_id_seq; Type: SEQUENCE; Schema: public; Owner: -
---
-
-ALTER TABLE public.users ALTER COLUMN id ADD GENERATED ALWAYS AS IDENTITY (
-    SEQUENCE NAME public.users_id_seq
-    START WITH 1
-    INCREMENT BY 1
-    NO MINVALUE
-    NO MAXVALUE
-    CACHE 1
-);
-`
-
-	_, err := db.Exec(stmt)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
 // insertData inserts a minimal amout of test data into the test database
 func insertData(db *sql.DB) error {
 
 	// insert all genres
-	stmt := `insert into categorys (category_name, created_at, updated_at)
+	stmt := `insert into categorys (category_name, slug, created_at, updated_at)
 	values 
-	('Science Fiction', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
-	('Fantasy', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
-	('Romance', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
-	('Thriller', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
-	('Mystery', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
-	('Horror', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
-	('Classic', '2020-01-01 01:00:00', '2020-01-01 01:00:00')`
+	('Science Fiction', 'science-fiction', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
+	('Fantasy', 'fantasy', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
+	('Romance', 'romance', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
+	('Thriller', 'thriller', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
+	('Mystery', 'mystery', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
+	('Horror', 'horror', '2020-01-01 01:00:00', '2020-01-01 01:00:00'),
+	('Classic', 'classic', '2020-01-01 01:00:00', '2020-01-01 01:00:00')`
 	_, err := db.Exec(stmt)
 	if err != nil {
 		return err
 	}
 
+	// insert one user, who writes the book
+	stmt = `insert into users (email, first_name, last_name, password, user_active, role, email_verified_at, created_at, updated_at)
+	values
+	('admin@example.com', 'Admin', 'User', '$2a$12$1zGLuYDDNvATh4RA4avbKuheAMpb1svexSzrQm7up.bnpwQHs0jNe', 1, 'admin', '2020-01-01 01:00:00', '2020-01-01 01:00:00', '2020-01-01 01:00:00')`
+	_, err = db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+
 	// insert one book
 	stmt = `
-	insert into blogs (title,createdby_id , content, created_at, updated_at, slug, description)
+	insert into blogs (title,createdby_id , content, created_at, updated_at, slug, description, status, published_at)
 	values
-	('My Blog', 1, yolo content, '2020-01-01 01:00:00', '2020-01-01 01:00:00', 'my-blog', 'My description')`
+	('My Blog', 1, 'yolo content', '2020-01-01 01:00:00', '2020-01-01 01:00:00', 'my-blog', 'My description', 'published', '2020-01-01 01:00:00')`
+	_, err = db.Exec(stmt)
+	if err != nil {
+		return err
+	}
+
+	// build the search index of the book
+	stmt = `update blogs set search_vector =
+		setweight(to_tsvector('english', coalesce(title, '')), 'A') ||
+		setweight(to_tsvector('english', coalesce(description, '')), 'B') ||
+		setweight(to_tsvector('english', coalesce(content, '')), 'C')`
 	_, err = db.Exec(stmt)
 	if err != nil {
 		return err
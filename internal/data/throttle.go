@@ -0,0 +1,138 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// LoginPolicy says how hard we make it to guess passwords. The first FreeAttempts failed logins cost nothing,
+// after that every failure doubles the wait before the next try, starting at BaseDelay. At MaxFailures the key
+// is locked for Lockout, and every failure after that locks it again. Failures are forgotten after a
+// successful login or when there was none for ForgetAfter
+type LoginPolicy struct {
+	FreeAttempts int
+	BaseDelay    time.Duration
+	MaxFailures  int
+	Lockout      time.Duration
+	ForgetAfter  time.Duration
+}
+
+// Delay is how long a key is blocked after its failures-th failed login in a row
+func (p LoginPolicy) Delay(failures int) time.Duration {
+	if p.MaxFailures > 0 && failures >= p.MaxFailures {
+		return p.Lockout
+	}
+
+	if failures <= p.FreeAttempts {
+		return 0
+	}
+
+	// the backoff never takes longer than a lockout
+	delay := p.BaseDelay
+	for i := p.FreeAttempts + 1; i < failures && delay < p.Lockout; i++ {
+		delay *= 2
+	}
+	if delay > p.Lockout {
+		delay = p.Lockout
+	}
+
+	return delay
+}
+
+// LoginThrottle keeps track of failed logins
+type LoginThrottle struct {
+	Key           string     `json:"key"`
+	Failures      int        `json:"failures"`
+	LastFailureAt time.Time  `json:"last_failure_at"`
+	BlockedUntil  *time.Time `json:"blocked_until,omitempty"`
+}
+
+// AccountKey and IPKey are the keys failed logins are counted under
+func AccountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func IPKey(ip string) string {
+	return "ip:" + ip
+}
+
+// Blocked returns how long until all of keys can try to log in again, 0 when they can now
+func (l *LoginThrottle) Blocked(keys ...string) (time.Duration, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	now := time.Now()
+
+	args := []interface{}{now}
+	placeholders := make([]string, len(keys))
+	for i, key := range keys {
+		args = append(args, key)
+		placeholders[i] = fmt.Sprintf("$%d", i+2)
+	}
+
+	query := fmt.Sprintf(`select max(blocked_until) from login_throttles where blocked_until > $1 and key in (%s)`,
+		strings.Join(placeholders, ", "))
+
+	var blockedUntil sql.NullTime
+	err := db.QueryRowContext(ctx, query, args...).Scan(&blockedUntil)
+	if err != nil {
+		return 0, err
+	}
+
+	if !blockedUntil.Valid {
+		return 0, nil
+	}
+
+	return blockedUntil.Time.Sub(now), nil
+}
+
+// Failed counts a failed login for key and blocks it for as long as policy says
+func (l *LoginThrottle) Failed(key string, policy LoginPolicy) (*LoginThrottle, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	throttle := LoginThrottle{Key: key, LastFailureAt: time.Now()}
+
+	err := withTransaction(ctx, func(tx *sql.Tx) error {
+		// an old count starts over
+		query := `insert into login_throttles (key, failures, last_failure_at) values ($1, 1, $2)
+			on conflict (key) do update set
+				failures = case when login_throttles.last_failure_at < $3 then 1 else login_throttles.failures + 1 end,
+				last_failure_at = excluded.last_failure_at
+			returning failures`
+
+		err := tx.QueryRowContext(ctx, query, key, throttle.LastFailureAt, throttle.LastFailureAt.Add(-policy.ForgetAfter)).
+			Scan(&throttle.Failures)
+		if err != nil {
+			return err
+		}
+
+		delay := policy.Delay(throttle.Failures)
+		if delay == 0 {
+			return nil
+		}
+
+		blockedUntil := throttle.LastFailureAt.Add(delay)
+		throttle.BlockedUntil = &blockedUntil
+
+		_, err = tx.ExecContext(ctx, `update login_throttles set blocked_until = $1 where key = $2`, blockedUntil, key)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &throttle, nil
+}
+
+// Reset forgets the failed logins of key, after a successful login or when an admin unlocks an account
+func (l *LoginThrottle) Reset(key string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `delete from login_throttles where key = $1`, key)
+	return err
+}
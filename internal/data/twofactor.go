@@ -0,0 +1,264 @@
+package data
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"errors"
+	"strings"
+	"thelsblog-server/internal/totp"
+	"time"
+)
+
+var (
+	// ErrTwoFactorNotEnabled is returned when a user without two factor authentication is asked for a code
+	ErrTwoFactorNotEnabled = errors.New("two factor authentication is not enabled")
+	// ErrTwoFactorAlreadyEnabled is returned when setting up two factor authentication a second time
+	ErrTwoFactorAlreadyEnabled = errors.New("two factor authentication is already enabled")
+	// ErrTwoFactorNotStarted is returned when confirming two factor authentication before it was set up
+	ErrTwoFactorNotStarted = errors.New("two factor authentication was not set up yet")
+	// ErrInvalidTwoFactorCode is returned for a wrong code, a code that was already used or a used recovery code
+	ErrInvalidTwoFactorCode = errors.New("invalid two factor code")
+)
+
+// how many recovery codes a user gets
+const recoveryCodeCount = 10
+
+// TwoFactor is the two factor authentication status of a user
+type TwoFactor struct {
+	Enabled           bool       `json:"enabled"`
+	EnabledAt         *time.Time `json:"enabled_at,omitempty"`
+	RecoveryCodesLeft int        `json:"recovery_codes_left"`
+}
+
+// GetTwoFactor returns the two factor authentication status of a user
+func (u *User) GetTwoFactor(id int) (*TwoFactor, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select totp_enabled_at,
+		(select count(*) from recovery_codes r where r.user_id = users.id and r.used_at is null)
+		from users where id = $1 and deleted_at is null`
+
+	var status TwoFactor
+	err := db.QueryRowContext(ctx, query, id).Scan(&status.EnabledAt, &status.RecoveryCodesLeft)
+	if err != nil {
+		return nil, err
+	}
+	status.Enabled = status.EnabledAt != nil
+
+	return &status, nil
+}
+
+// StartTOTP gives a user a new totp secret and returns it. It is not used until ConfirmTOTP is called
+// with a code from the authenticator app, so a user who does not finish setting it up can still log in
+func (u *User) StartTOTP(id int) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `update users set totp_secret = $1, totp_last_step = 0, updated_at = $2
+		where id = $3 and deleted_at is null and totp_enabled_at is null`
+
+	result, err := db.ExecContext(ctx, stmt, secret, time.Now(), id)
+	if err != nil {
+		return "", err
+	}
+
+	if err := expectRows(result); err != nil {
+		// either the user is not there, or they already have two factor authentication
+		if status, statusErr := u.GetTwoFactor(id); statusErr == nil && status.Enabled {
+			return "", ErrTwoFactorAlreadyEnabled
+		}
+		return "", err
+	}
+
+	return secret, nil
+}
+
+// ConfirmTOTP turns two factor authentication on when code is right for the secret from StartTOTP,
+// and returns the recovery codes of the user. They are only ever shown this once
+func (u *User) ConfirmTOTP(id int, code string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var codes []string
+
+	err := withTransaction(ctx, func(tx *sql.Tx) error {
+		var secret sql.NullString
+		var enabledAt *time.Time
+
+		err := tx.QueryRowContext(ctx, `select totp_secret, totp_enabled_at from users where id = $1 and deleted_at is null for update`,
+			id).Scan(&secret, &enabledAt)
+		if err != nil {
+			return err
+		}
+
+		if enabledAt != nil {
+			return ErrTwoFactorAlreadyEnabled
+		}
+		if !secret.Valid {
+			return ErrTwoFactorNotStarted
+		}
+
+		step, ok := totp.Validate(secret.String, code, time.Now())
+		if !ok {
+			return ErrInvalidTwoFactorCode
+		}
+
+		_, err = tx.ExecContext(ctx, `update users set totp_enabled_at = $1, totp_last_step = $2, updated_at = $1 where id = $3`,
+			time.Now(), step, id)
+		if err != nil {
+			return err
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// DisableTOTP turns two factor authentication off and removes the recovery codes
+func (u *User) DisableTOTP(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	return withTransaction(ctx, func(tx *sql.Tx) error {
+		stmt := `update users set totp_secret = null, totp_enabled_at = null, totp_last_step = 0, updated_at = $1
+			where id = $2 and deleted_at is null`
+
+		result, err := tx.ExecContext(ctx, stmt, time.Now(), id)
+		if err != nil {
+			return err
+		}
+		if err := expectRows(result); err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, id)
+		return err
+	})
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes of a user with new ones, the old ones stop working
+func (u *User) RegenerateRecoveryCodes(id int) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var codes []string
+
+	err := withTransaction(ctx, func(tx *sql.Tx) error {
+		var enabledAt *time.Time
+		err := tx.QueryRowContext(ctx, `select totp_enabled_at from users where id = $1 and deleted_at is null`, id).Scan(&enabledAt)
+		if err != nil {
+			return err
+		}
+
+		if enabledAt == nil {
+			return ErrTwoFactorNotEnabled
+		}
+
+		codes, err = replaceRecoveryCodes(ctx, tx, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return codes, nil
+}
+
+// CheckTwoFactor checks a code from the authenticator app, or one of the recovery codes, of a user.
+// Every code works only once, ErrInvalidTwoFactorCode is returned for a wrong or used code
+func (u *User) CheckTwoFactor(id int, code string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	var secret sql.NullString
+	var enabledAt *time.Time
+
+	err := db.QueryRowContext(ctx, `select totp_secret, totp_enabled_at from users where id = $1 and deleted_at is null`,
+		id).Scan(&secret, &enabledAt)
+	if err != nil {
+		return err
+	}
+
+	if enabledAt == nil || !secret.Valid {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if step, ok := totp.Validate(secret.String, code, time.Now()); ok {
+		// only a step after the last one used, so a code that was seen once can't be used again
+		result, err := db.ExecContext(ctx, `update users set totp_last_step = $1 where id = $2 and totp_last_step < $1`, step, id)
+		if err != nil {
+			return err
+		}
+		if err := expectRows(result); err != nil {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	stmt := `update recovery_codes set used_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`
+
+	result, err := db.ExecContext(ctx, stmt, time.Now(), id, hashToken(normalizeRecoveryCode(code)))
+	if err != nil {
+		return err
+	}
+	if err := expectRows(result); err != nil {
+		return ErrInvalidTwoFactorCode
+	}
+
+	return nil
+}
+
+// replaceRecoveryCodes deletes the recovery codes of a user and stores recoveryCodeCount new ones,
+// the plain text codes are returned
+func replaceRecoveryCodes(ctx context.Context, q dbtx, userID int) ([]string, error) {
+	_, err := q.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	var codes []string
+
+	for i := 0; i < recoveryCodeCount; i++ {
+		randomBytes := make([]byte, 10)
+		_, err := rand.Read(randomBytes)
+		if err != nil {
+			return nil, err
+		}
+
+		// 16 characters, written as xxxx-xxxx-xxxx-xxxx so they are easier to copy
+		raw := strings.ToLower(encoding.EncodeToString(randomBytes))
+		code := raw[0:4] + "-" + raw[4:8] + "-" + raw[8:12] + "-" + raw[12:16]
+
+		_, err = q.ExecContext(ctx, `insert into recovery_codes (user_id, code_hash, created_at) values ($1, $2, $3)`,
+			userID, hashToken(normalizeRecoveryCode(code)), time.Now())
+		if err != nil {
+			return nil, err
+		}
+
+		codes = append(codes, code)
+	}
+
+	return codes, nil
+}
+
+// normalizeRecoveryCode makes a recovery code typed in with other dashes, spaces or capitals match the stored one
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
@@ -0,0 +1,55 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+)
+
+// dbtx is what *sql.DB and *sql.Tx have in common, so a helper can run its statements
+// either on their own or as part of a transaction
+type dbtx interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// withTransaction runs fn inside a transaction. The transaction is committed when fn returns nil
+// and rolled back when it returns an error (or panics), so fn never has to commit or roll back itself
+func withTransaction(ctx context.Context, fn func(tx *sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			_ = tx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			_ = tx.Rollback()
+		}
+	}()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// expectRows returns sql.ErrNoRows when a statement did not touch any rows,
+// for updates and deletes of one row that should fail when the row is not there
+func expectRows(result sql.Result) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
@@ -0,0 +1,129 @@
+package data
+
+import (
+	"context"
+	"time"
+)
+
+// WebAuthnCredential is a passkey of a user
+type WebAuthnCredential struct {
+	ID           int        `json:"id"`
+	UserID       int        `json:"user_id"`
+	CredentialID []byte     `json:"credential_id"`
+	PublicKey    []byte     `json:"-"`
+	SignCount    int64      `json:"-"`
+	Name         string     `json:"name"`
+	CreatedAt    time.Time  `json:"created_at"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty"`
+}
+
+// Insert saves a new credential and returns its id
+func (c *WebAuthnCredential) Insert(credential WebAuthnCredential) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	stmt := `insert into webauthn_credentials (user_id, credential_id, public_key, sign_count, name, created_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	var id int
+	err := db.QueryRowContext(ctx, stmt,
+		credential.UserID,
+		credential.CredentialID,
+		credential.PublicKey,
+		credential.SignCount,
+		credential.Name,
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// GetAllForUser returns the credentials of a user, the oldest first
+func (c *WebAuthnCredential) GetAllForUser(userID int) ([]*WebAuthnCredential, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at
+		from webauthn_credentials where user_id = $1 order by created_at`
+
+	rows, err := db.QueryContext(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var credentials []*WebAuthnCredential
+
+	for rows.Next() {
+		var credential WebAuthnCredential
+		err := rows.Scan(
+			&credential.ID,
+			&credential.UserID,
+			&credential.CredentialID,
+			&credential.PublicKey,
+			&credential.SignCount,
+			&credential.Name,
+			&credential.CreatedAt,
+			&credential.LastUsedAt,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		credentials = append(credentials, &credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+// GetByCredentialID returns the credential with the id the authenticator gave it
+func (c *WebAuthnCredential) GetByCredentialID(credentialID []byte) (*WebAuthnCredential, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	query := `select id, user_id, credential_id, public_key, sign_count, name, created_at, last_used_at
+		from webauthn_credentials where credential_id = $1`
+
+	var credential WebAuthnCredential
+	err := db.QueryRowContext(ctx, query, credentialID).Scan(
+		&credential.ID,
+		&credential.UserID,
+		&credential.CredentialID,
+		&credential.PublicKey,
+		&credential.SignCount,
+		&credential.Name,
+		&credential.CreatedAt,
+		&credential.LastUsedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &credential, nil
+}
+
+// Used stores the signature counter of a credential after a login with it
+func (c *WebAuthnCredential) Used(id int, signCount int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	_, err := db.ExecContext(ctx, `update webauthn_credentials set sign_count = $1, last_used_at = $2 where id = $3`,
+		signCount, time.Now(), id)
+	return err
+}
+
+// DeleteForUser removes a credential of a user, sql.ErrNoRows is returned when the user has no credential with that id
+func (c *WebAuthnCredential) DeleteForUser(id, userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), dbTimeout)
+	defer cancel()
+
+	result, err := db.ExecContext(ctx, `delete from webauthn_credentials where id = $1 and user_id = $2`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
@@ -0,0 +1,265 @@
+// Package diff builds unified diffs between two texts, line by line
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// MaxLines is the most lines a text can have to be diffed. The diff takes longer the more lines there
+// are and the more they differ, this keeps a diff of two huge texts from hogging the server
+const MaxLines = 10000
+
+// ErrTooLarge is returned by Unified when a text has more than MaxLines lines
+var ErrTooLarge = fmt.Errorf("texts with more than %d lines can't be diffed", MaxLines)
+
+// operation is one step of the edit script turning a into b
+type operation struct {
+	kind byte // ' ' for a line in both, '-' for a line only in a and '+' for a line only in b
+	line string
+	aPos int // line number in a, counted from 0
+	bPos int // line number in b, counted from 0
+}
+
+// Unified returns the unified diff between a and b, with context lines of context around each change.
+// fromName and toName end up in the --- and +++ header lines. An empty string is returned when a and b are equal,
+// ErrTooLarge when one of them has too many lines
+func Unified(fromName, toName, a, b string, context int) (string, error) {
+	if a == b {
+		return "", nil
+	}
+
+	aLines := splitLines(a)
+	bLines := splitLines(b)
+	if len(aLines) > MaxLines || len(bLines) > MaxLines {
+		return "", ErrTooLarge
+	}
+
+	ops := editScript(aLines, bLines)
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "--- %s\n", fromName)
+	fmt.Fprintf(&out, "+++ %s\n", toName)
+
+	for _, h := range hunks(ops, context) {
+		writeHunk(&out, ops[h[0]:h[1]])
+	}
+
+	return out.String(), nil
+}
+
+// splitLines splits s into lines, without the line endings
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.TrimSuffix(s, "\n")
+	return strings.Split(s, "\n")
+}
+
+// editScript works out the shortest list of operations turning a into b. It uses the linear space
+// variant of Myers' algorithm: find the middle snake of the shortest edit path, then do both halves the
+// same way. The time is O((N+M)D) for D differences and the memory O(N+M), it never builds an N*M table
+func editScript(a, b []string) []operation {
+	d := differ{a: a, b: b}
+	d.compare(0, len(a), 0, len(b))
+	return d.ops
+}
+
+// differ collects the operations of editScript in order
+type differ struct {
+	a, b []string
+	ops  []operation
+}
+
+// compare adds the operations turning a[aLo:aHi] into b[bLo:bHi]
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	// lines both start or end with are kept as they are
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.keep(aLo, bLo)
+		aLo++
+		bLo++
+	}
+
+	suffix := 0
+	for aLo < aHi-suffix && bLo < bHi-suffix && d.a[aHi-1-suffix] == d.b[bHi-1-suffix] {
+		suffix++
+	}
+	aHi -= suffix
+	bHi -= suffix
+
+	switch {
+	case aLo == aHi:
+		for j := bLo; j < bHi; j++ {
+			d.ops = append(d.ops, operation{kind: '+', line: d.b[j], aPos: aLo, bPos: j})
+		}
+	case bLo == bHi:
+		for i := aLo; i < aHi; i++ {
+			d.ops = append(d.ops, operation{kind: '-', line: d.a[i], aPos: i, bPos: bLo})
+		}
+	default:
+		// both halves are smaller than the whole, the first and last lines differ so there are at least 2 edits
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		for i := 0; i < u-x; i++ {
+			d.keep(x+i, y+i)
+		}
+		d.compare(u, aHi, v, bHi)
+	}
+
+	for i := 0; i < suffix; i++ {
+		d.keep(aHi+i, bHi+i)
+	}
+}
+
+func (d *differ) keep(i, j int) {
+	d.ops = append(d.ops, operation{kind: ' ', line: d.a[i], aPos: i, bPos: j})
+}
+
+// middleSnake finds the snake, the run of equal lines, in the middle of a shortest edit path between
+// a[aLo:aHi] and b[bLo:bHi] by searching from the start and from the end at the same time.
+// The snake goes from a[x], b[y] up to a[u], b[v]
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	maxD := (n + m + 1) / 2
+
+	// forward[k] is how far into a the furthest path from the start on diagonal k (x - y) got, backward[k] the
+	// same for paths from the end with x and y counted from the end
+	offset := maxD + 1
+	forward := make([]int, 2*maxD+3)
+	backward := make([]int, 2*maxD+3)
+
+	for D := 0; D <= maxD; D++ {
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			} else {
+				x = forward[offset+k-1] + 1
+			}
+			y := x - k
+
+			startX, startY := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			forward[offset+k] = x
+
+			// the backward paths of the last round are on the diagonals delta - k
+			if kb := delta - k; odd && kb >= -(D-1) && kb <= D-1 && x+backward[offset+kb] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y
+			}
+		}
+
+		for k := -D; k <= D; k += 2 {
+			var x int
+			if k == -D || (k != D && backward[offset+k-1] < backward[offset+k+1]) {
+				x = backward[offset+k+1]
+			} else {
+				x = backward[offset+k-1] + 1
+			}
+			y := x - k
+
+			startX, startY := x, y
+			for x < n && y < m && d.a[aHi-1-x] == d.b[bHi-1-y] {
+				x++
+				y++
+			}
+			backward[offset+k] = x
+
+			if kf := delta - k; !odd && kf >= -D && kf <= D && x+forward[offset+kf] >= n {
+				return aHi - x, bHi - y, aHi - startX, bHi - startY
+			}
+		}
+	}
+
+	// there is always a path of at most n + m edits, so we never get here
+	panic("diff: no middle snake")
+}
+
+// hunks groups the changes in ops together with their context lines,
+// every hunk is returned as a [start, end) range of ops
+func hunks(ops []operation, context int) [][2]int {
+	var result [][2]int
+
+	for i := 0; i < len(ops); i++ {
+		if ops[i].kind == ' ' {
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		// keep going while the next change is close enough to share context with this one
+		end := i
+		for end < len(ops) {
+			if ops[end].kind != ' ' {
+				end++
+				continue
+			}
+
+			next := end
+			for next < len(ops) && ops[next].kind == ' ' {
+				next++
+			}
+
+			if next == len(ops) || next-end > 2*context {
+				end += context
+				if end > len(ops) {
+					end = len(ops)
+				}
+				break
+			}
+			end = next
+		}
+
+		// merge with the previous hunk if they overlap
+		if len(result) > 0 && start <= result[len(result)-1][1] {
+			result[len(result)-1][1] = end
+		} else {
+			result = append(result, [2]int{start, end})
+		}
+		i = end - 1
+	}
+
+	return result
+}
+
+// writeHunk writes one hunk, with its @@ header, to out
+func writeHunk(out *strings.Builder, ops []operation) {
+	aStart, bStart := ops[0].aPos, ops[0].bPos
+	aCount, bCount := 0, 0
+	for _, op := range ops {
+		if op.kind != '+' {
+			aCount++
+		}
+		if op.kind != '-' {
+			bCount++
+		}
+	}
+
+	fmt.Fprintf(out, "@@ -%s +%s @@\n", hunkRange(aStart, aCount), hunkRange(bStart, bCount))
+	for _, op := range ops {
+		out.WriteByte(op.kind)
+		out.WriteString(op.line)
+		out.WriteByte('\n')
+	}
+}
+
+// hunkRange formats the start,count part of a hunk header, line numbers in the header start from 1
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
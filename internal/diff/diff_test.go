@@ -0,0 +1,108 @@
+package diff
+
+import (
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	var theTests = []struct {
+		name     string
+		a        string
+		b        string
+		expected string
+	}{
+		{"equal", "one\ntwo\n", "one\ntwo\n", ""},
+		{"changed line", "one\ntwo\nthree\n", "one\n2\nthree\n",
+			"--- a\n+++ b\n@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n"},
+		{"added line", "one\nthree", "one\ntwo\nthree",
+			"--- a\n+++ b\n@@ -1,2 +1,3 @@\n one\n+two\n three\n"},
+		{"from empty", "", "one\n",
+			"--- a\n+++ b\n@@ -0,0 +1 @@\n+one\n"},
+		{"two hunks", "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n", "x\n2\n3\n4\n5\n6\n7\n8\n9\ny\n",
+			"--- a\n+++ b\n@@ -1,2 +1,2 @@\n-1\n+x\n 2\n@@ -9,2 +9,2 @@\n 9\n-10\n+y\n"},
+		{"merged hunks", "1\n2\n3\n4\n", "x\n2\n3\ny\n",
+			"--- a\n+++ b\n@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n-4\n+y\n"},
+	}
+
+	for _, e := range theTests {
+		got, err := Unified("a", "b", e.a, e.b, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != e.expected {
+			t.Errorf("%s: expected\n%q\nbut got\n%q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestUnified_tooLarge(t *testing.T) {
+	huge := strings.Repeat("x\n", MaxLines+1)
+
+	_, err := Unified("a", "b", "x\n", huge, 3)
+	if !errors.Is(err, ErrTooLarge) {
+		t.Errorf("expected ErrTooLarge but got %v", err)
+	}
+}
+
+func TestEditScript(t *testing.T) {
+	// random texts from a few different lines, so there is plenty in common and plenty that differs
+	random := rand.New(rand.NewSource(1))
+	text := func() []string {
+		lines := make([]string, random.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + random.Intn(4)))
+		}
+		return lines
+	}
+
+	for n := 0; n < 500; n++ {
+		a, b := text(), text()
+		ops := editScript(a, b)
+
+		// the script has to turn a into b
+		var gotA, gotB []string
+		edits := 0
+		for _, op := range ops {
+			if op.kind != '+' {
+				gotA = append(gotA, op.line)
+			}
+			if op.kind != '-' {
+				gotB = append(gotB, op.line)
+			}
+			if op.kind != ' ' {
+				edits++
+			}
+		}
+		if strings.Join(gotA, ",") != strings.Join(a, ",") || strings.Join(gotB, ",") != strings.Join(b, ",") {
+			t.Fatalf("%v to %v: the script does not turn one into the other", a, b)
+		}
+
+		// and be as short as it gets
+		if expected := len(a) + len(b) - 2*lcsLength(a, b); edits != expected {
+			t.Fatalf("%v to %v: expected %d edits but got %d", a, b, expected, edits)
+		}
+	}
+}
+
+// lcsLength is the length of the longest common subsequence of a and b, the slow way
+func lcsLength(a, b []string) int {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	return lcs[0][0]
+}
@@ -0,0 +1,194 @@
+// Package jwt signs and verifies the JSON web tokens we use as access tokens, signed with
+// ed25519 (EdDSA) or a shared secret (HS256). Only the compact form and these two algorithms are supported
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrInvalidToken is returned for a token that is malformed, has a bad signature or was issued by someone else
+	ErrInvalidToken = errors.New("invalid token")
+	// ErrExpiredToken is returned for a token that was valid once
+	ErrExpiredToken = errors.New("token has expired")
+)
+
+// Claims is what we put in a token
+type Claims struct {
+	Subject   string `json:"sub"`
+	Email     string `json:"email,omitempty"`
+	Role      string `json:"role"`
+	SessionID string `json:"sid,omitempty"`
+	Issuer    string `json:"iss"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// header is the first part of a token
+type header struct {
+	Algorithm string `json:"alg"`
+	Type      string `json:"typ"`
+	KeyID     string `json:"kid"`
+}
+
+// Signer signs tokens with its first key and verifies tokens signed by any of its keys
+type Signer struct {
+	issuer  string
+	current Key
+	keys    map[string]Key
+	ordered []Key
+}
+
+// New returns a Signer for keys, which are used like ParseKeys describes. issuer goes in the iss claim
+// of every token, and a token with another issuer is not accepted
+func New(issuer string, keys []Key) (*Signer, error) {
+	if len(keys) == 0 {
+		return nil, errors.New("at least one key is needed to sign tokens")
+	}
+
+	s := &Signer{
+		issuer:  issuer,
+		current: keys[0],
+		keys:    make(map[string]Key),
+		ordered: keys,
+	}
+
+	for _, key := range keys {
+		if _, ok := s.keys[key.ID]; ok {
+			return nil, fmt.Errorf("key id %s is used twice", key.ID)
+		}
+		s.keys[key.ID] = key
+	}
+
+	return s, nil
+}
+
+var encoding = base64.RawURLEncoding
+
+// Sign returns a token for claims, the issuer is filled in and iat as well when it is not set
+func (s *Signer) Sign(claims Claims) (string, error) {
+	claims.Issuer = s.issuer
+	if claims.IssuedAt == 0 {
+		claims.IssuedAt = time.Now().Unix()
+	}
+
+	h, err := json.Marshal(header{Algorithm: s.current.Algorithm, Type: "JWT", KeyID: s.current.ID})
+	if err != nil {
+		return "", err
+	}
+
+	c, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(h) + "." + encoding.EncodeToString(c)
+
+	return signingInput + "." + encoding.EncodeToString(sign(s.current, signingInput)), nil
+}
+
+// sign signs the first two parts of a token with key
+func sign(key Key, signingInput string) []byte {
+	if key.Algorithm == AlgEdDSA {
+		return ed25519.Sign(key.private, []byte(signingInput))
+	}
+
+	mac := hmac.New(sha256.New, key.secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+// Verify checks the signature, issuer and expiry of token and returns its claims
+func (s *Signer) Verify(token string) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var h header
+	if err := decodePart(parts[0], &h); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	key, ok := s.keys[h.KeyID]
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	// the algorithm comes with the key, not from the token. Otherwise a token could say it is HS256
+	// and be signed with our public key as the secret
+	if h.Algorithm != key.Algorithm {
+		return nil, ErrInvalidToken
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	signingInput := parts[0] + "." + parts[1]
+
+	if key.Algorithm == AlgEdDSA {
+		if !ed25519.Verify(key.public, []byte(signingInput), signature) {
+			return nil, ErrInvalidToken
+		}
+	} else if !hmac.Equal(signature, sign(key, signingInput)) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodePart(parts[1], &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	if claims.Issuer != s.issuer {
+		return nil, ErrInvalidToken
+	}
+
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return nil, ErrExpiredToken
+	}
+
+	return &claims, nil
+}
+
+// decodePart decodes one base64 part of a token into v
+func decodePart(part string, v interface{}) error {
+	b, err := encoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// JWKS returns the public keys, so other services can verify our tokens themselves.
+// HS256 secrets can't be published, so only the EdDSA keys are in there
+func (s *Signer) JWKS() JWKS {
+	set := JWKS{Keys: []JWK{}}
+
+	// the signing key first, then the rest in the order they were given
+	for _, key := range s.ordered {
+		if key.Algorithm != AlgEdDSA {
+			continue
+		}
+
+		set.Keys = append(set.Keys, JWK{
+			KeyType:   "OKP",
+			Curve:     "Ed25519",
+			KeyID:     key.ID,
+			Algorithm: AlgEdDSA,
+			Use:       "sig",
+			X:         encoding.EncodeToString(key.public),
+		})
+	}
+
+	return set
+}
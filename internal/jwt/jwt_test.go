@@ -0,0 +1,155 @@
+package jwt
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testKeys(t *testing.T) (Key, Key) {
+	ed, err := NewEd25519Key("ed-1", bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hs, err := NewHS256Key("hs-1", bytes.Repeat([]byte{2}, minSecretLength))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return ed, hs
+}
+
+func TestSigner_SignAndVerify(t *testing.T) {
+	ed, hs := testKeys(t)
+
+	for _, key := range []Key{ed, hs} {
+		s, err := New("thelsblog", []Key{key})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := s.Sign(Claims{Subject: "1", Role: "admin", SessionID: "abc", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		claims, err := s.Verify(token)
+		if err != nil {
+			t.Fatalf("%s: expected the token to verify but got %v", key.Algorithm, err)
+		}
+		if claims.Subject != "1" || claims.Role != "admin" || claims.SessionID != "abc" || claims.Issuer != "thelsblog" {
+			t.Errorf("%s: got the wrong claims back: %+v", key.Algorithm, claims)
+		}
+	}
+}
+
+func TestSigner_Verify(t *testing.T) {
+	ed, hs := testKeys(t)
+
+	s, _ := New("thelsblog", []Key{ed})
+	valid, _ := s.Sign(Claims{Subject: "1", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	expired, _ := s.Sign(Claims{Subject: "1", ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+
+	other, _ := New("someone-else", []Key{ed})
+	otherIssuer, _ := other.Sign(Claims{Subject: "1", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+
+	unknown, _ := New("thelsblog", []Key{hs})
+	unknownKey, _ := unknown.Sign(Claims{Subject: "1", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+
+	parts := strings.Split(valid, ".")
+	tampered := parts[0] + "." + encoding.EncodeToString([]byte(`{"sub":"2","iss":"thelsblog","exp":9999999999}`)) + "." + parts[2]
+
+	// an HS256 token signed with our public key as the secret
+	confusedKey := Key{ID: ed.ID, Algorithm: AlgHS256, secret: ed.public}
+	confused, _ := (&Signer{issuer: "thelsblog", current: confusedKey}).Sign(Claims{Subject: "1", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+
+	none := encoding.EncodeToString([]byte(`{"alg":"none","kid":"ed-1"}`)) + "." + parts[1] + "."
+
+	var theTests = []struct {
+		name     string
+		token    string
+		expected error
+	}{
+		{"valid", valid, nil},
+		{"expired", expired, ErrExpiredToken},
+		{"other issuer", otherIssuer, ErrInvalidToken},
+		{"unknown key", unknownKey, ErrInvalidToken},
+		{"tampered", tampered, ErrInvalidToken},
+		{"algorithm confusion", confused, ErrInvalidToken},
+		{"alg none", none, ErrInvalidToken},
+		{"garbage", "not.a.token", ErrInvalidToken},
+		{"no dots", "abc", ErrInvalidToken},
+	}
+
+	for _, e := range theTests {
+		_, err := s.Verify(e.token)
+		if !errors.Is(err, e.expected) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, err)
+		}
+	}
+}
+
+func TestSigner_Rotation(t *testing.T) {
+	ed, hs := testKeys(t)
+
+	before, _ := New("thelsblog", []Key{hs})
+	oldToken, _ := before.Sign(Claims{Subject: "1", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+
+	// the new key signs, the old one still verifies what it signed
+	after, err := New("thelsblog", []Key{ed, hs})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := after.Verify(oldToken); err != nil {
+		t.Errorf("expected a token of the old key to still verify but got %v", err)
+	}
+
+	newToken, _ := after.Sign(Claims{Subject: "1", ExpiresAt: time.Now().Add(time.Minute).Unix()})
+	h, _ := encoding.DecodeString(strings.Split(newToken, ".")[0])
+	if !strings.Contains(string(h), `"kid":"ed-1"`) {
+		t.Errorf("expected the new key to sign but got header %s", h)
+	}
+
+	// only the public ed25519 key is published
+	jwks := after.JWKS()
+	if len(jwks.Keys) != 1 || jwks.Keys[0].KeyID != "ed-1" || jwks.Keys[0].X != encoding.EncodeToString(ed.public) {
+		t.Errorf("expected only the ed25519 key in the jwks but got %+v", jwks.Keys)
+	}
+
+	if _, err := New("thelsblog", []Key{ed, ed}); err == nil {
+		t.Error("expected an error for a key id that is used twice")
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	seed := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	secret := base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{2}, minSecretLength))
+
+	keys, err := ParseKeys("new:EdDSA:" + seed + ", old:HS256:" + secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].ID != "new" || keys[0].Algorithm != AlgEdDSA || keys[1].ID != "old" || keys[1].Algorithm != AlgHS256 {
+		t.Errorf("got the wrong keys: %+v", keys)
+	}
+
+	var badSpecs = []string{
+		"new:EdDSA",
+		"new:RS256:" + seed,
+		"new:EdDSA:not base64!",
+		"new:HS256:" + base64.StdEncoding.EncodeToString([]byte("short")),
+		":EdDSA:" + seed,
+	}
+
+	for _, spec := range badSpecs {
+		if _, err := ParseKeys(spec); err == nil {
+			t.Errorf("expected an error for %q", spec)
+		}
+	}
+}
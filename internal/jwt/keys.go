@@ -0,0 +1,119 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// the algorithms we sign with
+const (
+	AlgEdDSA = "EdDSA"
+	AlgHS256 = "HS256"
+)
+
+// minSecretLength is the shortest HS256 secret we accept, the same size as the hash
+const minSecretLength = 32
+
+// Key is one signing key, tokens name the key they were signed with in the kid header
+type Key struct {
+	ID        string
+	Algorithm string
+
+	// for EdDSA
+	private ed25519.PrivateKey
+	public  ed25519.PublicKey
+
+	// for HS256, this one is never published
+	secret []byte
+}
+
+// NewEd25519Key makes a key from a 32 byte ed25519 seed
+func NewEd25519Key(id string, seed []byte) (Key, error) {
+	if id == "" {
+		return Key{}, errors.New("a key needs an id")
+	}
+	if len(seed) != ed25519.SeedSize {
+		return Key{}, fmt.Errorf("key %s: an ed25519 seed is %d bytes, got %d", id, ed25519.SeedSize, len(seed))
+	}
+
+	private := ed25519.NewKeyFromSeed(seed)
+	return Key{
+		ID:        id,
+		Algorithm: AlgEdDSA,
+		private:   private,
+		public:    private.Public().(ed25519.PublicKey),
+	}, nil
+}
+
+// NewHS256Key makes a key from a shared secret of at least 32 bytes
+func NewHS256Key(id string, secret []byte) (Key, error) {
+	if id == "" {
+		return Key{}, errors.New("a key needs an id")
+	}
+	if len(secret) < minSecretLength {
+		return Key{}, fmt.Errorf("key %s: an HS256 secret needs at least %d bytes, got %d", id, minSecretLength, len(secret))
+	}
+
+	return Key{ID: id, Algorithm: AlgHS256, secret: secret}, nil
+}
+
+// ParseKeys reads keys from a comma separated list of kid:algorithm:base64 entries, like
+//
+//	2024-06:EdDSA:<base64 of a 32 byte seed>,2024-01:HS256:<base64 of the secret>
+//
+// the first key signs new tokens, the others are only there to verify tokens signed before a key rotation
+func ParseKeys(spec string) ([]Key, error) {
+	var keys []Key
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) != 3 {
+			return nil, fmt.Errorf("key %q is not in the form kid:algorithm:base64", entry)
+		}
+
+		material, err := base64.StdEncoding.DecodeString(parts[2])
+		if err != nil {
+			return nil, fmt.Errorf("key %s is not valid base64: %s", parts[0], err.Error())
+		}
+
+		var key Key
+		switch parts[1] {
+		case AlgEdDSA:
+			key, err = NewEd25519Key(parts[0], material)
+		case AlgHS256:
+			key, err = NewHS256Key(parts[0], material)
+		default:
+			err = fmt.Errorf("key %s has unknown algorithm %s, use %s or %s", parts[0], parts[1], AlgEdDSA, AlgHS256)
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+// JWK is the public part of a key as published in a JWKS, see RFC 8037 for ed25519 keys
+type JWK struct {
+	KeyType   string `json:"kty"`
+	Curve     string `json:"crv"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	X         string `json:"x"`
+}
+
+// JWKS is the document served at /.well-known/jwks.json
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
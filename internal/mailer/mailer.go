@@ -0,0 +1,146 @@
+// Package mailer sends the emails of the api, like password reset links, over SMTP
+package mailer
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"text/template"
+	"time"
+)
+
+// every email is one template file in templates/, defining a "subject", a "plainBody" and an "htmlBody" template
+//
+//go:embed templates
+var templateFS embed.FS
+
+// Mailer sends emails through one SMTP server. MailHog from docker-compose.yml listens on localhost:1025
+// and does not need a username or password
+type Mailer struct {
+	host     string
+	port     int
+	username string
+	password string
+	sender   *mail.Address
+}
+
+// New returns a Mailer sending through host:port as sender, auth is only used when username is set.
+// sender can have a display name, like "ThelsBlog <no-reply@thelsblog.com>"
+func New(host string, port int, username, password, sender string) (Mailer, error) {
+	from, err := mail.ParseAddress(sender)
+	if err != nil {
+		return Mailer{}, fmt.Errorf("invalid sender %q: %w", sender, err)
+	}
+
+	return Mailer{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		sender:   from,
+	}, nil
+}
+
+// Send renders templateFile with data and emails it to recipient, with both a plain text and an html body
+func (m Mailer) Send(recipient, templateFile string, data interface{}) error {
+	subject, plainBody, htmlBody, err := render(templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	msg, err := m.message(recipient, subject, plainBody, htmlBody)
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.username != "" {
+		auth = smtp.PlainAuth("", m.username, m.password, m.host)
+	}
+
+	// the envelope only takes the bare address, the display name is for the From header
+	addr := m.host + ":" + strconv.Itoa(m.port)
+	return smtp.SendMail(addr, auth, m.sender.Address, []string{recipient}, msg)
+}
+
+// render executes the three templates of templateFile
+func render(templateFile string, data interface{}) (string, string, string, error) {
+	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	subject := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(subject, "subject", data); err != nil {
+		return "", "", "", err
+	}
+
+	plainBody := new(bytes.Buffer)
+	if err := tmpl.ExecuteTemplate(plainBody, "plainBody", data); err != nil {
+		return "", "", "", err
+	}
+
+	// the html body goes through html/template, so whatever ends up in data gets escaped
+	htmlTmpl, err := htmltemplate.New("email").ParseFS(templateFS, "templates/"+templateFile)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	htmlBody := new(bytes.Buffer)
+	if err := htmlTmpl.ExecuteTemplate(htmlBody, "htmlBody", data); err != nil {
+		return "", "", "", err
+	}
+
+	return subject.String(), plainBody.String(), htmlBody.String(), nil
+}
+
+// message builds a multipart/alternative email, mail clients show the html part when they can
+func (m Mailer) message(recipient, subject, plainBody, htmlBody string) ([]byte, error) {
+	body := new(bytes.Buffer)
+	parts := multipart.NewWriter(body)
+
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain", plainBody},
+		{"text/html", htmlBody},
+	} {
+		w, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=UTF-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	msg := new(bytes.Buffer)
+	fmt.Fprintf(msg, "From: %s\r\n", m.sender.String())
+	fmt.Fprintf(msg, "To: %s\r\n", recipient)
+	fmt.Fprintf(msg, "Subject: %s\r\n", mime.QEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(msg, "Content-Type: multipart/alternative; boundary=%s\r\n", parts.Boundary())
+	fmt.Fprintf(msg, "\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
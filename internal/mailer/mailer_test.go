@@ -0,0 +1,166 @@
+package mailer
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+// fakeSMTP is just enough of an SMTP server to accept one email, which it sends on messages.
+// The MAIL FROM line of the envelope goes on envelopes
+func fakeSMTP(t *testing.T) (string, int, <-chan string, <-chan string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+
+	messages := make(chan string, 1)
+	envelopes := make(chan string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) { _, _ = io.WriteString(conn, s+"\r\n") }
+
+		reply("220 fake smtp")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+
+			switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				reply("250 hello")
+			case strings.HasPrefix(cmd, "MAIL FROM"):
+				envelopes <- strings.TrimSpace(line)
+				reply("250 ok")
+			case strings.HasPrefix(cmd, "DATA"):
+				reply("354 go ahead")
+				var msg strings.Builder
+				for {
+					l, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if l == ".\r\n" {
+						break
+					}
+					msg.WriteString(l)
+				}
+				messages <- msg.String()
+				reply("250 queued")
+			case strings.HasPrefix(cmd, "QUIT"):
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+
+	addr := l.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, messages, envelopes
+}
+
+func TestMailer_Send(t *testing.T) {
+	host, port, messages, envelopes := fakeSMTP(t)
+	m, err := New(host, port, "", "", "ThelsBlog <no-reply@thelsblog.com>")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	data := map[string]interface{}{
+		"Name":      "Jack <script>",
+		"Link":      "http://localhost:8081/reset-password?token=abc",
+		"ExpiresIn": "1 hour",
+	}
+
+	err = m.Send("jack@example.com", "password_reset.tmpl", data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// only the bare address goes in the envelope, the display name is just for the From header
+	if envelope := <-envelopes; envelope != "MAIL FROM:<no-reply@thelsblog.com>" {
+		t.Errorf("expected the envelope to be from no-reply@thelsblog.com but got %q", envelope)
+	}
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-messages))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if msg.Header.Get("From") != `"ThelsBlog" <no-reply@thelsblog.com>` {
+		t.Errorf("expected the email to be from ThelsBlog but it is from %q", msg.Header.Get("From"))
+	}
+	if msg.Header.Get("To") != "jack@example.com" {
+		t.Errorf("expected the email to go to jack@example.com but it goes to %q", msg.Header.Get("To"))
+	}
+	if !strings.Contains(msg.Header.Get("Subject"), "Reset your ThelsBlog password") {
+		t.Errorf("unexpected subject %q", msg.Header.Get("Subject"))
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("expected a multipart/alternative email but got %q", msg.Header.Get("Content-Type"))
+	}
+
+	bodies := make(map[string]string)
+	parts := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, err := parts.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		// NextPart already undoes the quoted-printable encoding
+		content, _ := io.ReadAll(part)
+		contentType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		bodies[contentType] = string(content)
+	}
+
+	if !strings.Contains(bodies["text/plain"], data["Link"].(string)) {
+		t.Errorf("expected the plain body to have the link but got %q", bodies["text/plain"])
+	}
+	if !strings.Contains(bodies["text/html"], `href="http://localhost:8081/reset-password?token=abc"`) {
+		t.Errorf("expected the html body to have the link but got %q", bodies["text/html"])
+	}
+	if strings.Contains(bodies["text/html"], "<script>") {
+		t.Error("expected the html body to escape the data")
+	}
+}
+
+func TestMailer_Send_unknownTemplate(t *testing.T) {
+	m, err := New("127.0.0.1", 1, "", "", "no-reply@thelsblog.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = m.Send("jack@example.com", "nope.tmpl", nil)
+	if err == nil {
+		t.Error("expected an error for a template that does not exist")
+	}
+}
+
+func TestNew_invalidSender(t *testing.T) {
+	_, err := New("127.0.0.1", 1, "", "", "ThelsBlog no-reply@thelsblog.com")
+	if err == nil {
+		t.Error("expected an error for a sender that is not an email address")
+	}
+}
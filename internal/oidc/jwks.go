@@ -0,0 +1,158 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"time"
+)
+
+// jwk is one key in the JWKS of the provider
+type jwk struct {
+	KeyType string `json:"kty"`
+	KeyID   string `json:"kid"`
+	Use     string `json:"use"`
+	Curve   string `json:"crv"`
+	N       string `json:"n"`
+	E       string `json:"e"`
+	X       string `json:"x"`
+	Y       string `json:"y"`
+}
+
+// jwksRefetchInterval is how long we wait before fetching the keys again for a kid we don't know,
+// so tokens with made up key ids can't make us hammer the provider
+const jwksRefetchInterval = time.Minute
+
+// key returns the signing key with id kid. The keys are fetched again when kid is not known,
+// since that is what happens when the provider rotates its keys
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	p.mu.Lock()
+	key, ok := p.keys[kid]
+	recent := time.Since(p.keysAt) < jwksRefetchInterval
+	p.mu.Unlock()
+	if ok {
+		return key, nil
+	}
+	if recent {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+	}
+
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	err = p.getJSON(ctx, d.JWKSURI, &set)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]interface{})
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		// keys we can't use are skipped, the provider may publish more kinds than we support
+		if public, err := k.publicKey(); err == nil {
+			keys[k.KeyID] = public
+		}
+	}
+
+	p.mu.Lock()
+	p.keys = keys
+	p.keysAt = time.Now()
+	p.mu.Unlock()
+
+	key, ok = keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidIDToken, kid)
+	}
+
+	return key, nil
+}
+
+// publicKey turns a jwk into a crypto public key
+func (k jwk) publicKey() (interface{}, error) {
+	decode := base64.RawURLEncoding.DecodeString
+
+	switch k.KeyType {
+	case "RSA":
+		n, err := decode(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decode(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid exponent")
+		}
+		exponent := 0
+		for _, c := range e {
+			exponent = exponent<<8 | int(c)
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}, nil
+
+	case "EC":
+		if k.Curve != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decode(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("point is not on the curve")
+		}
+		return key, nil
+
+	case "OKP":
+		if k.Curve != "Ed25519" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Curve)
+		}
+		x, err := decode(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid ed25519 key")
+		}
+		return ed25519.PublicKey(x), nil
+	}
+
+	return nil, fmt.Errorf("unsupported key type %s", k.KeyType)
+}
+
+// verifySignature checks a JWS signature. The algorithm has to match the type of the key,
+// so a token can't pick a weaker way of checking it
+func verifySignature(alg string, key interface{}, signingInput, signature []byte) bool {
+	hash := sha256.Sum256(signingInput)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return alg == "RS256" && rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	case *ecdsa.PublicKey:
+		// JWS signatures are r and s next to each other, not ASN.1
+		if alg != "ES256" || len(signature) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		return ecdsa.Verify(key, hash[:], r, s)
+	case ed25519.PublicKey:
+		return alg == "EdDSA" && ed25519.Verify(key, signingInput, signature)
+	}
+
+	return false
+}
@@ -0,0 +1,326 @@
+// Package oidc logs users in with an external OpenID Connect provider, using the authorization code flow with PKCE.
+// The provider is found through discovery, and ID tokens are checked against the keys it publishes
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrInvalidIDToken is returned for an ID token that does not check out
+var ErrInvalidIDToken = errors.New("oidc: invalid id token")
+
+// Config is how we are registered with the provider
+type Config struct {
+	// Issuer is the url of the provider, discovery happens at Issuer/.well-known/openid-configuration
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is our callback, it has to be registered with the provider
+	RedirectURL string
+	// Scopes are asked for on top of openid
+	Scopes []string
+}
+
+// Provider is one OpenID Connect provider
+type Provider struct {
+	config Config
+	client *http.Client
+
+	mu        sync.Mutex
+	discovery *discovery
+	keys      map[string]interface{}
+	keysAt    time.Time
+}
+
+// discovery is the part of the provider metadata we use
+type discovery struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// New returns a Provider for config. Nothing is fetched yet, discovery happens the first time it is needed,
+// so a provider that is down does not keep us from starting. client is used for every request to the provider
+func New(config Config, client *http.Client) (*Provider, error) {
+	if config.Issuer == "" || config.ClientID == "" || config.RedirectURL == "" {
+		return nil, errors.New("oidc: issuer, client id and redirect url are needed")
+	}
+
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	config.Issuer = strings.TrimSuffix(config.Issuer, "/")
+
+	return &Provider{config: config, client: client}, nil
+}
+
+// Issuer is the issuer of the provider, without a trailing slash
+func (p *Provider) Issuer() string {
+	return p.config.Issuer
+}
+
+// getDiscovery fetches the provider metadata once
+func (p *Provider) getDiscovery(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	var d discovery
+	err := p.getJSON(ctx, p.config.Issuer+"/.well-known/openid-configuration", &d)
+	if err != nil {
+		return nil, err
+	}
+
+	// the metadata has to be about the issuer we asked, otherwise anybody serving it could issue tokens
+	if strings.TrimSuffix(d.Issuer, "/") != p.config.Issuer {
+		return nil, fmt.Errorf("oidc: discovery is for issuer %q, expected %q", d.Issuer, p.config.Issuer)
+	}
+	if d.AuthorizationEndpoint == "" || d.TokenEndpoint == "" || d.JWKSURI == "" {
+		return nil, errors.New("oidc: discovery document is missing endpoints")
+	}
+
+	p.discovery = &d
+	return p.discovery, nil
+}
+
+// getJSON gets url and decodes the json in the response into v
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: %s returned %s", url, res.Status)
+	}
+
+	return json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(v)
+}
+
+// RandomString returns a random url safe string, for the state, the nonce and the PKCE verifier
+func RandomString() (string, error) {
+	randomBytes := make([]byte, 32)
+	_, err := rand.Read(randomBytes)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// CodeChallenge is the S256 PKCE challenge for verifier
+func CodeChallenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// AuthCodeURL returns the url of the provider to send the user to. state comes back with the user,
+// nonce comes back in the ID token, and verifier has to be given to Exchange
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", p.config.ClientID)
+	v.Set("redirect_uri", p.config.RedirectURL)
+	v.Set("scope", strings.Join(append([]string{"openid"}, p.config.Scopes...), " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", CodeChallenge(verifier))
+	v.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(d.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+
+	return d.AuthorizationEndpoint + separator + v.Encode(), nil
+}
+
+// Claims are the claims of an ID token we use
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	AuthorizedBy  string   `json:"azp"`
+	ExpiresAt     int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+	GivenName     string   `json:"given_name"`
+	FamilyName    string   `json:"family_name"`
+}
+
+// audience is the aud claim, which is either one string or a list of them
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var one string
+	if err := json.Unmarshal(data, &one); err == nil {
+		*a = audience{one}
+		return nil
+	}
+
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = many
+	return nil
+}
+
+// Exchange swaps the code the provider sent the user back with for tokens, and returns the verified
+// claims of the ID token. nonce and verifier are the ones given to AuthCodeURL
+func (p *Provider) Exchange(ctx context.Context, code, nonce, verifier string) (*Claims, error) {
+	d, err := p.getDiscovery(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", p.config.RedirectURL)
+	form.Set("code_verifier", verifier)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	// client_secret_basic, the id and secret are form encoded first (RFC 6749 section 2.3.1)
+	req.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+
+	res, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	var tokens struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	err = json.NewDecoder(io.LimitReader(res.Body, 1<<20)).Decode(&tokens)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: invalid token response: %s", err.Error())
+	}
+
+	if res.StatusCode != http.StatusOK || tokens.Error != "" {
+		return nil, fmt.Errorf("oidc: token request failed: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+
+	if tokens.IDToken == "" {
+		return nil, errors.New("oidc: no id token in the token response")
+	}
+
+	return p.VerifyIDToken(ctx, tokens.IDToken, nonce)
+}
+
+// idTokenLeeway is how far our clock may be off from the one of the provider
+const idTokenLeeway = time.Minute
+
+// VerifyIDToken checks the signature, issuer, audience, expiry and nonce of an ID token and returns its claims
+func (p *Provider) VerifyIDToken(ctx context.Context, raw, nonce string) (*Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidIDToken
+	}
+
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	key, err := p.key(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	if !verifySignature(header.Algorithm, key, []byte(parts[0]+"."+parts[1]), signature) {
+		return nil, ErrInvalidIDToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, ErrInvalidIDToken
+	}
+
+	now := time.Now()
+
+	switch {
+	case strings.TrimSuffix(claims.Issuer, "/") != p.config.Issuer:
+		return nil, fmt.Errorf("%w: wrong issuer", ErrInvalidIDToken)
+	case !claims.Audience.contains(p.config.ClientID):
+		return nil, fmt.Errorf("%w: not for us", ErrInvalidIDToken)
+	case len(claims.Audience) > 1 && claims.AuthorizedBy != p.config.ClientID:
+		return nil, fmt.Errorf("%w: not authorized for us", ErrInvalidIDToken)
+	case now.After(time.Unix(claims.ExpiresAt, 0).Add(idTokenLeeway)):
+		return nil, fmt.Errorf("%w: expired", ErrInvalidIDToken)
+	case time.Unix(claims.IssuedAt, 0).After(now.Add(idTokenLeeway)):
+		return nil, fmt.Errorf("%w: issued in the future", ErrInvalidIDToken)
+	case claims.Nonce == "" || claims.Nonce != nonce:
+		return nil, fmt.Errorf("%w: wrong nonce", ErrInvalidIDToken)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+
+	return &claims, nil
+}
+
+func (a audience) contains(clientID string) bool {
+	for _, aud := range a {
+		if aud == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeSegment decodes one base64url part of a token into v
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,143 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"testing"
+	"thelsblog-server/internal/oidc/oidctest"
+	"time"
+)
+
+const testRedirect = "http://localhost:8082/auth/oidc/callback"
+
+func testProvider(t *testing.T) (*Provider, *oidctest.Provider) {
+	mock := oidctest.NewProvider("thelsblog", "secret")
+	t.Cleanup(mock.Close)
+
+	p, err := New(Config{Issuer: mock.URL, ClientID: "thelsblog", ClientSecret: "secret", RedirectURL: testRedirect,
+		Scopes: []string{"email", "profile"}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p, mock
+}
+
+// authorize follows the auth code url to the mock provider and returns the code and state it sends back
+func authorize(t *testing.T, p *Provider, state, nonce, verifier string) (string, string) {
+	authURL, err := p.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("expected a redirect back but got %d", res.StatusCode)
+	}
+
+	return location.Query().Get("code"), location.Query().Get("state")
+}
+
+func TestProvider_Login(t *testing.T) {
+	p, mock := testProvider(t)
+	mock.User = oidctest.User{Subject: "abc", Email: "editor@example.com", EmailVerified: true, GivenName: "Ed"}
+
+	code, state := authorize(t, p, "the-state", "the-nonce", "the-verifier-that-is-long-enough-for-pkce-0123")
+	if state != "the-state" {
+		t.Errorf("expected the state back but got %q", state)
+	}
+
+	claims, err := p.Exchange(context.Background(), code, "the-nonce", "the-verifier-that-is-long-enough-for-pkce-0123")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims.Subject != "abc" || claims.Email != "editor@example.com" || !claims.EmailVerified || claims.GivenName != "Ed" {
+		t.Errorf("got the wrong claims: %+v", claims)
+	}
+
+	// a code works once
+	_, err = p.Exchange(context.Background(), code, "the-nonce", "the-verifier-that-is-long-enough-for-pkce-0123")
+	if err == nil {
+		t.Error("expected a used code to fail")
+	}
+}
+
+func TestProvider_Exchange_invalid(t *testing.T) {
+	verifier := "the-verifier-that-is-long-enough-for-pkce-0123"
+
+	var theTests = []struct {
+		name     string
+		claims   func(map[string]interface{})
+		nonce    string
+		verifier string
+	}{
+		{"wrong verifier", nil, "n", "another-verifier-that-is-long-enough-for-pkce-01"},
+		{"wrong nonce", nil, "other nonce", verifier},
+		{"other audience", func(c map[string]interface{}) { c["aud"] = "someone-else" }, "n", verifier},
+		{"other issuer", func(c map[string]interface{}) { c["iss"] = "https://evil.example.com" }, "n", verifier},
+		{"expired", func(c map[string]interface{}) { c["exp"] = time.Now().Add(-time.Hour).Unix() }, "n", verifier},
+		{"several audiences without azp", func(c map[string]interface{}) { c["aud"] = []string{"thelsblog", "other"} }, "n", verifier},
+	}
+
+	for _, e := range theTests {
+		p, mock := testProvider(t)
+		mock.Claims = e.claims
+
+		code, _ := authorize(t, p, "s", "n", verifier)
+
+		_, err := p.Exchange(context.Background(), code, e.nonce, e.verifier)
+		if err == nil {
+			t.Errorf("%s: expected the exchange to fail", e.name)
+		}
+	}
+}
+
+func TestProvider_VerifyIDToken_signature(t *testing.T) {
+	p, mock := testProvider(t)
+
+	claims := map[string]interface{}{
+		"iss":   mock.URL,
+		"sub":   "abc",
+		"aud":   "thelsblog",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": "n",
+	}
+
+	token := mock.Sign(claims)
+	if _, err := p.VerifyIDToken(context.Background(), token, "n"); err != nil {
+		t.Fatalf("expected the token to verify but got %v", err)
+	}
+
+	// signed by another provider with the same key id
+	other := oidctest.NewProvider("thelsblog", "secret")
+	defer other.Close()
+
+	_, err := p.VerifyIDToken(context.Background(), other.Sign(claims), "n")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("expected ErrInvalidIDToken for a token of another key but got %v", err)
+	}
+
+	_, err = p.VerifyIDToken(context.Background(), "a.b.c", "n")
+	if !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("expected ErrInvalidIDToken for garbage but got %v", err)
+	}
+}
+
+func TestCodeChallenge(t *testing.T) {
+	// the example from RFC 7636 appendix B
+	got := CodeChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	if got != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("expected the challenge from the RFC but got %s", got)
+	}
+}
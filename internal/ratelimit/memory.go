@@ -0,0 +1,70 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often MemoryStore forgets buckets that have filled up again
+const sweepInterval = time.Minute
+
+// MemoryStore keeps the buckets in memory, they are lost on a restart
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]memoryBucket
+	lastSweep time.Time
+	// now is time.Now, tests change it
+	now func() time.Time
+}
+
+type memoryBucket struct {
+	Bucket
+	// forgetAt is when the bucket is full again
+	forgetAt time.Time
+}
+
+// NewMemoryStore returns an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		buckets: make(map[string]memoryBucket),
+		now:     time.Now,
+	}
+}
+
+// Take takes a token from the bucket of key
+func (s *MemoryStore) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.sweep(now)
+
+	bucket, result := limit.Take(s.buckets[key].Bucket, now)
+	s.buckets[key] = memoryBucket{Bucket: bucket, forgetAt: now.Add(result.Reset)}
+
+	return result, nil
+}
+
+// Len is the number of buckets in the store
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.buckets)
+}
+
+// sweep forgets the full buckets, a new bucket is full as well so nobody notices. Keys are ip addresses
+// and users, without this the map would keep growing
+func (s *MemoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+	s.lastSweep = now
+
+	for key, bucket := range s.buckets {
+		if !now.Before(bucket.forgetAt) {
+			delete(s.buckets, key)
+		}
+	}
+}
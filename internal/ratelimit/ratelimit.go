@@ -0,0 +1,118 @@
+// Package ratelimit limits how often something can be done with token buckets. Every key has a bucket of Burst
+// tokens that refills at Requests per Period, a request takes a token and has to wait when there is none left
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is how many requests a key can make
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	// Burst is how many requests can be made at once, Requests when it is 0
+	Burst int
+}
+
+// ParseLimit reads a limit like 20/1m, 20 requests a minute, or 20/1m/5 for at most 5 at once
+func ParseLimit(s string) (Limit, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 {
+		return Limit{}, fmt.Errorf("rate limit %q is not requests/period or requests/period/burst", s)
+	}
+
+	var limit Limit
+	var err error
+
+	limit.Requests, err = strconv.Atoi(parts[0])
+	if err != nil || limit.Requests <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q needs a positive number of requests", s)
+	}
+
+	limit.Period, err = time.ParseDuration(parts[1])
+	if err != nil || limit.Period <= 0 {
+		return Limit{}, fmt.Errorf("rate limit %q needs a positive period", s)
+	}
+
+	if len(parts) == 3 {
+		limit.Burst, err = strconv.Atoi(parts[2])
+		if err != nil || limit.Burst <= 0 {
+			return Limit{}, fmt.Errorf("rate limit %q needs a positive burst", s)
+		}
+	}
+
+	return limit, nil
+}
+
+// Policy describes l for the RateLimit-Policy header, like 20;w=60;burst=5
+func (l Limit) Policy() string {
+	return fmt.Sprintf("%d;w=%d;burst=%d", l.Requests, int(math.Ceil(l.Period.Seconds())), l.burst())
+}
+
+func (l Limit) burst() int {
+	if l.Burst > 0 {
+		return l.Burst
+	}
+	return l.Requests
+}
+
+// interval is how long it takes to get one token back
+func (l Limit) interval() time.Duration {
+	return l.Period / time.Duration(l.Requests)
+}
+
+// Bucket is what a store keeps for every key. The zero Bucket is full
+type Bucket struct {
+	Tokens  float64
+	Updated time.Time
+}
+
+// Result is what happened to a request
+type Result struct {
+	Allowed bool
+	// Limit is the size of the bucket and Remaining how many tokens are left in it
+	Limit     int
+	Remaining int
+	// RetryAfter is how long until the next token when the request was not allowed
+	RetryAfter time.Duration
+	// Reset is how long until the bucket is full again
+	Reset time.Duration
+}
+
+// Take refills b for the time since it was last updated and takes a token out if there is one.
+// It returns the new state of the bucket, stores use it so they all count the same way
+func (l Limit) Take(b Bucket, now time.Time) (Bucket, Result) {
+	burst := float64(l.burst())
+	interval := l.interval()
+
+	tokens := burst
+	if !b.Updated.IsZero() {
+		tokens = math.Min(burst, b.Tokens+float64(now.Sub(b.Updated))/float64(interval))
+	}
+
+	result := Result{Limit: l.burst()}
+
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = time.Duration((1 - tokens) * float64(interval))
+	}
+
+	result.Remaining = int(tokens)
+	result.Reset = time.Duration((burst - tokens) * float64(interval))
+
+	return Bucket{Tokens: tokens, Updated: now}, result
+}
+
+// Store keeps the buckets. MemoryStore is enough for one server, when there are more of them behind a load
+// balancer they need a shared store, for example in redis, or every server gives out its own tokens
+type Store interface {
+	// Take takes a token from the bucket of key, see Limit.Take
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
@@ -0,0 +1,101 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	var theTests = []struct {
+		input    string
+		expected Limit
+		valid    bool
+	}{
+		{"10/1m", Limit{Requests: 10, Period: time.Minute}, true},
+		{"300/1m/60", Limit{Requests: 300, Period: time.Minute, Burst: 60}, true},
+		{"10", Limit{}, false},
+		{"0/1m", Limit{}, false},
+		{"10/soon", Limit{}, false},
+		{"10/1m/-1", Limit{}, false},
+		{"10/1m/5/1", Limit{}, false},
+	}
+
+	for _, e := range theTests {
+		limit, err := ParseLimit(e.input)
+		if (err == nil) != e.valid {
+			t.Errorf("%s: expected valid to be %t but got error %v", e.input, e.valid, err)
+		}
+		if limit != e.expected {
+			t.Errorf("%s: expected %+v but got %+v", e.input, e.expected, limit)
+		}
+	}
+}
+
+func TestLimit_Take(t *testing.T) {
+	// 6 a minute is one every 10 seconds, 2 at once
+	limit := Limit{Requests: 6, Period: time.Minute, Burst: 2}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	var bucket Bucket
+	var result Result
+
+	for i := 0; i < 2; i++ {
+		bucket, result = limit.Take(bucket, now)
+		if !result.Allowed {
+			t.Fatalf("request %d: expected the burst to be allowed", i)
+		}
+	}
+	if result.Remaining != 0 || result.Limit != 2 || result.Reset != 20*time.Second {
+		t.Errorf("expected an empty bucket that is full in 20s but got %+v", result)
+	}
+
+	bucket, result = limit.Take(bucket, now.Add(4*time.Second))
+	if result.Allowed || result.RetryAfter != 6*time.Second {
+		t.Errorf("expected to retry after 6s but got %+v", result)
+	}
+
+	// the refused request did not cost anything
+	_, result = limit.Take(bucket, now.Add(10*time.Second))
+	if !result.Allowed || result.Remaining != 0 {
+		t.Errorf("expected a token after 10s but got %+v", result)
+	}
+
+	// a bucket never has more than the burst
+	_, result = limit.Take(bucket, now.Add(time.Hour))
+	if !result.Allowed || result.Remaining != 1 {
+		t.Errorf("expected a full bucket but got %+v", result)
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+
+	limit := Limit{Requests: 1, Period: time.Minute}
+	ctx := context.Background()
+
+	result, _ := store.Take(ctx, "a", limit)
+	if !result.Allowed {
+		t.Error("expected the first request of a to be allowed")
+	}
+	result, _ = store.Take(ctx, "a", limit)
+	if result.Allowed {
+		t.Error("expected the second request of a to be refused")
+	}
+
+	// keys have their own buckets
+	result, _ = store.Take(ctx, "b", limit)
+	if !result.Allowed {
+		t.Error("expected the first request of b to be allowed")
+	}
+
+	// full buckets are forgotten
+	now = now.Add(2 * time.Minute)
+	_, _ = store.Take(ctx, "c", limit)
+	if store.Len() != 1 {
+		t.Errorf("expected only c to be left but there are %d buckets", store.Len())
+	}
+}
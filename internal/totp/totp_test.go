@@ -0,0 +1,81 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 test vectors from RFC 6238 appendix B, with the last 6 of their 8 digits
+func TestCode(t *testing.T) {
+	secret := encoding.EncodeToString([]byte("12345678901234567890"))
+
+	var theTests = []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, e := range theTests {
+		got, err := Code(secret, Step(time.Unix(e.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != e.expected {
+			t.Errorf("at %d: expected %s but got %s", e.unix, e.expected, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Unix(1700000000, 0)
+	code, _ := Code(secret, Step(now))
+	previous, _ := Code(secret, Step(now)-1)
+	tooOld, _ := Code(secret, Step(now)-2)
+
+	var theTests = []struct {
+		name     string
+		code     string
+		expected bool
+	}{
+		{"current", code, true},
+		{"with a space", code[:3] + " " + code[3:], true},
+		{"previous period", previous, true},
+		{"too old", tooOld, false},
+		{"too short", code[:5], false},
+		{"letters", "abcdef", false},
+	}
+
+	for _, e := range theTests {
+		_, ok := Validate(secret, e.code, now)
+		if ok != e.expected {
+			t.Errorf("%s: expected %t but got %t", e.name, e.expected, ok)
+		}
+	}
+
+	step, _ := Validate(secret, previous, now)
+	if step != Step(now)-1 {
+		t.Errorf("expected the step of the previous period but got %d", step)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("ThelsBlog", "admin@example.com", "JBSWY3DPEHPK3PXP")
+
+	for _, part := range []string{"otpauth://totp/ThelsBlog:admin@example.com?", "secret=JBSWY3DPEHPK3PXP", "issuer=ThelsBlog", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("expected %s in %s", part, uri)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+	flagExtensions   = 0x80
+)
+
+// authenticatorData is what the authenticator signs, see section 6.1 of the WebAuthn spec
+type authenticatorData struct {
+	rpIDHash  []byte
+	flags     byte
+	signCount uint32
+
+	// only there when registering
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData reads authenticator data, the attested credential data is only read when its flag is set
+func parseAuthenticatorData(b []byte) (*authenticatorData, error) {
+	// rp id hash, flags and the sign count
+	if len(b) < 37 {
+		return nil, errors.New("authenticator data is too short")
+	}
+
+	data := &authenticatorData{
+		rpIDHash:  b[:32],
+		flags:     b[32],
+		signCount: binary.BigEndian.Uint32(b[33:37]),
+	}
+
+	rest := b[37:]
+
+	if data.flags&flagAttestedData != 0 {
+		// aaguid and the length of the credential id
+		if len(rest) < 18 {
+			return nil, errors.New("attested credential data is too short")
+		}
+
+		idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+		if idLength == 0 || idLength > 1023 || len(rest) < idLength {
+			return nil, errors.New("invalid credential id length")
+		}
+		data.credentialID = rest[:idLength]
+		rest = rest[idLength:]
+
+		// the public key is a CBOR map, we only know where it ends by decoding it
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		data.publicKey = rest[:n]
+		rest = rest[n:]
+	}
+
+	if data.flags&flagExtensions != 0 {
+		_, n, err := decodeCBOR(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[n:]
+	}
+
+	if len(rest) != 0 {
+		return nil, errors.New("authenticator data has trailing bytes")
+	}
+
+	return data, nil
+}
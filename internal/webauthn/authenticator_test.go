@@ -0,0 +1,180 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"testing"
+)
+
+// encodeCBOR is the other half of decodeCBOR, enough to build what an authenticator sends
+func encodeCBOR(v interface{}) []byte {
+	head := func(major byte, n uint64) []byte {
+		switch {
+		case n < 24:
+			return []byte{major<<5 | byte(n)}
+		case n < 1<<8:
+			return []byte{major<<5 | 24, byte(n)}
+		case n < 1<<16:
+			b := []byte{major<<5 | 25, 0, 0}
+			binary.BigEndian.PutUint16(b[1:], uint16(n))
+			return b
+		default:
+			b := []byte{major<<5 | 26, 0, 0, 0, 0}
+			binary.BigEndian.PutUint32(b[1:], uint32(n))
+			return b
+		}
+	}
+
+	switch v := v.(type) {
+	case int:
+		if v < 0 {
+			return head(1, uint64(-1-v))
+		}
+		return head(0, uint64(v))
+	case []byte:
+		return append(head(2, uint64(len(v))), v...)
+	case string:
+		return append(head(3, uint64(len(v))), v...)
+	case map[interface{}]interface{}:
+		// sorted, so the output is the same every time
+		var keys []string
+		encoded := make(map[string][]byte)
+		for key, value := range v {
+			k := string(encodeCBOR(key))
+			keys = append(keys, k)
+			encoded[k] = encodeCBOR(value)
+		}
+		sort.Strings(keys)
+
+		out := head(5, uint64(len(v)))
+		for _, k := range keys {
+			out = append(out, k...)
+			out = append(out, encoded[k]...)
+		}
+		return out
+	}
+
+	panic("encodeCBOR: unsupported type")
+}
+
+// softAuthenticator is an authenticator in software, for tests
+type softAuthenticator struct {
+	rpID      string
+	origin    string
+	id        []byte
+	signer    crypto.Signer
+	signCount uint32
+	flags     byte
+}
+
+func newSoftAuthenticator(t *testing.T, rpID, origin string, alg int) *softAuthenticator {
+	a := &softAuthenticator{rpID: rpID, origin: origin, id: make([]byte, 16), flags: flagUserPresent | flagUserVerified}
+	_, _ = rand.Read(a.id)
+
+	var err error
+	if alg == AlgEdDSA {
+		_, a.signer, err = ed25519.GenerateKey(rand.Reader)
+	} else {
+		a.signer, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a
+}
+
+// coseKey is the public key of the authenticator as a COSE key
+func (a *softAuthenticator) coseKey() []byte {
+	switch key := a.signer.Public().(type) {
+	case *ecdsa.PublicKey:
+		x, y := make([]byte, 32), make([]byte, 32)
+		key.X.FillBytes(x)
+		key.Y.FillBytes(y)
+		return encodeCBOR(map[interface{}]interface{}{coseKty: 2, coseAlg: AlgES256, coseCrv: 1, coseX: x, coseY: y})
+	case ed25519.PublicKey:
+		return encodeCBOR(map[interface{}]interface{}{coseKty: 1, coseAlg: AlgEdDSA, coseCrv: 6, coseX: []byte(key)})
+	}
+	return nil
+}
+
+func (a *softAuthenticator) clientData(ceremony string, challenge []byte) []byte {
+	c, _ := json.Marshal(clientData{
+		Type:      ceremony,
+		Challenge: base64.RawURLEncoding.EncodeToString(challenge),
+		Origin:    a.origin,
+	})
+	return c
+}
+
+func (a *softAuthenticator) authData(attested bool) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+
+	data := append([]byte(nil), rpIDHash[:]...)
+	flags := a.flags
+	if attested {
+		flags |= flagAttestedData
+	}
+	data = append(data, flags)
+	data = binary.BigEndian.AppendUint32(data, a.signCount)
+
+	if attested {
+		data = append(data, make([]byte, 16)...) // aaguid
+		data = binary.BigEndian.AppendUint16(data, uint16(len(a.id)))
+		data = append(data, a.id...)
+		data = append(data, a.coseKey()...)
+	}
+
+	return data
+}
+
+// create answers navigator.credentials.create()
+func (a *softAuthenticator) create(challenge []byte) RegistrationResponse {
+	var r RegistrationResponse
+	r.ID = base64.RawURLEncoding.EncodeToString(a.id)
+	r.RawID = a.id
+	r.Type = "public-key"
+	r.Response.ClientDataJSON = a.clientData("webauthn.create", challenge)
+	r.Response.AttestationObject = encodeCBOR(map[interface{}]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[interface{}]interface{}{},
+		"authData": a.authData(true),
+	})
+	return r
+}
+
+// get answers navigator.credentials.get()
+func (a *softAuthenticator) get(t *testing.T, challenge []byte) AssertionResponse {
+	a.signCount++
+
+	var r AssertionResponse
+	r.ID = base64.RawURLEncoding.EncodeToString(a.id)
+	r.RawID = a.id
+	r.Type = "public-key"
+	r.Response.ClientDataJSON = a.clientData("webauthn.get", challenge)
+	r.Response.AuthenticatorData = a.authData(false)
+
+	clientDataHash := sha256.Sum256(r.Response.ClientDataJSON)
+	message := append(append([]byte(nil), r.Response.AuthenticatorData...), clientDataHash[:]...)
+
+	var err error
+	if _, ok := a.signer.(ed25519.PrivateKey); ok {
+		r.Response.Signature, err = a.signer.Sign(rand.Reader, message, crypto.Hash(0))
+	} else {
+		hash := sha256.Sum256(message)
+		r.Response.Signature, err = a.signer.Sign(rand.Reader, hash[:], crypto.SHA256)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
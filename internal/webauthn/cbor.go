@@ -0,0 +1,153 @@
+package webauthn
+
+import (
+	"errors"
+	"fmt"
+)
+
+// the subset of CBOR (RFC 8949) authenticators use for attestation objects and COSE keys:
+// integers, byte and text strings, arrays, maps, booleans and null, all with definite lengths
+
+// maxCBORDepth stops deeply nested input from using up the stack
+const maxCBORDepth = 16
+
+var errCBORTruncated = errors.New("cbor: unexpected end of data")
+
+// decodeCBOR decodes the first CBOR value in b and returns it together with the number of bytes it took.
+// Unsigned and negative integers become int64, byte strings []byte, text strings string,
+// arrays []interface{} and maps map[interface{}]interface{}
+func decodeCBOR(b []byte) (interface{}, int, error) {
+	d := cborDecoder{data: b}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, 0, err
+	}
+	return v, d.pos, nil
+}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// head reads the initial byte of an item and its argument
+func (d *cborDecoder) head() (byte, uint64, error) {
+	if d.pos >= len(d.data) {
+		return 0, 0, errCBORTruncated
+	}
+
+	initial := d.data[d.pos]
+	d.pos++
+	major, info := initial>>5, initial&0x1f
+
+	var size int
+	switch {
+	case info < 24:
+		return major, uint64(info), nil
+	case info == 24:
+		size = 1
+	case info == 25:
+		size = 2
+	case info == 26:
+		size = 4
+	case info == 27:
+		size = 8
+	default:
+		return 0, 0, fmt.Errorf("cbor: unsupported additional information %d", info)
+	}
+
+	if len(d.data)-d.pos < size {
+		return 0, 0, errCBORTruncated
+	}
+
+	var arg uint64
+	for _, c := range d.data[d.pos : d.pos+size] {
+		arg = arg<<8 | uint64(c)
+	}
+	d.pos += size
+
+	return major, arg, nil
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > maxCBORDepth {
+		return nil, errors.New("cbor: nested too deep")
+	}
+
+	major, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+
+	switch major {
+	case 0:
+		if arg > 1<<63-1 {
+			return nil, errors.New("cbor: integer too large")
+		}
+		return int64(arg), nil
+	case 1:
+		if arg > 1<<63-1 {
+			return nil, errors.New("cbor: integer too large")
+		}
+		return -1 - int64(arg), nil
+	case 2, 3:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBORTruncated
+		}
+		raw := d.data[d.pos : d.pos+int(arg)]
+		d.pos += int(arg)
+		if major == 3 {
+			return string(raw), nil
+		}
+		return append([]byte(nil), raw...), nil
+	case 4:
+		// every item takes at least one byte, which keeps a bogus length from allocating a lot
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBORTruncated
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	case 5:
+		if arg > uint64(len(d.data)-d.pos) {
+			return nil, errCBORTruncated
+		}
+		m := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errors.New("cbor: map keys must be integers or text")
+			}
+
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = item
+		}
+		return m, nil
+	case 7:
+		switch arg {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22:
+			return nil, nil
+		}
+		return nil, fmt.Errorf("cbor: unsupported simple value %d", arg)
+	}
+
+	return nil, fmt.Errorf("cbor: unsupported major type %d", major)
+}
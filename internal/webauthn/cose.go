@@ -0,0 +1,111 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithm ids (RFC 9053) of the keys we accept, in the order we prefer them
+const (
+	AlgES256 = -7
+	AlgEdDSA = -8
+	AlgRS256 = -257
+)
+
+// SupportedAlgorithms goes in pubKeyCredParams of the registration options
+var SupportedAlgorithms = []int{AlgES256, AlgEdDSA, AlgRS256}
+
+// COSE key map labels
+const (
+	coseKty = 1
+	coseAlg = 3
+	coseCrv = -1 // for RSA keys this is n
+	coseX   = -2 // for RSA keys this is e
+	coseY   = -3
+)
+
+// publicKey is a parsed COSE key
+type publicKey struct {
+	alg int64
+	key crypto.PublicKey
+}
+
+// parsePublicKey reads a COSE_Key as an authenticator stores it in the attested credential data
+func parsePublicKey(cose []byte) (*publicKey, error) {
+	v, n, err := decodeCBOR(cose)
+	if err != nil {
+		return nil, err
+	}
+	if n != len(cose) {
+		return nil, errors.New("public key has trailing data")
+	}
+
+	m, ok := v.(map[interface{}]interface{})
+	if !ok {
+		return nil, errors.New("public key is not a map")
+	}
+
+	kty, _ := m[int64(coseKty)].(int64)
+	alg, _ := m[int64(coseAlg)].(int64)
+
+	switch alg {
+	case AlgES256:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		y, _ := m[int64(coseY)].([]byte)
+		if kty != 2 || crv != 1 || len(x) != 32 || len(y) != 32 {
+			return nil, errors.New("invalid ES256 public key")
+		}
+
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("ES256 public key is not on the curve")
+		}
+		return &publicKey{alg: alg, key: key}, nil
+
+	case AlgEdDSA:
+		crv, _ := m[int64(coseCrv)].(int64)
+		x, _ := m[int64(coseX)].([]byte)
+		if kty != 1 || crv != 6 || len(x) != ed25519.PublicKeySize {
+			return nil, errors.New("invalid EdDSA public key")
+		}
+		return &publicKey{alg: alg, key: ed25519.PublicKey(x)}, nil
+
+	case AlgRS256:
+		n, _ := m[int64(coseCrv)].([]byte)
+		e, _ := m[int64(coseX)].([]byte)
+		if kty != 3 || len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, errors.New("invalid RS256 public key")
+		}
+
+		exponent := 0
+		for _, c := range e {
+			exponent = exponent<<8 | int(c)
+		}
+		return &publicKey{alg: alg, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: exponent}}, nil
+	}
+
+	return nil, fmt.Errorf("unsupported public key algorithm %d", alg)
+}
+
+// verify checks signature over message
+func (k *publicKey) verify(message, signature []byte) bool {
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(message)
+		return ecdsa.VerifyASN1(key, hash[:], signature)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, message, signature)
+	case *rsa.PublicKey:
+		hash := sha256.Sum256(message)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	}
+	return false
+}
@@ -0,0 +1,401 @@
+// Package webauthn is the relying party side of WebAuthn, which is what passkeys are built on. It makes the
+// options for navigator.credentials.create() and .get() and verifies what the browser sends back.
+// Attestation is not checked: we ask for "none", so we don't know (or care) which authenticator made a credential
+package webauthn
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrClonedAuthenticator is returned when the signature counter of a credential went backwards,
+// which means there are two copies of its private key
+var ErrClonedAuthenticator = errors.New("webauthn: signature counter went backwards, the authenticator may be cloned")
+
+// Bytes is binary data that is base64url encoded in JSON, like PublicKeyCredential.toJSON() does it in the browser
+type Bytes []byte
+
+// MarshalJSON encodes b as unpadded base64url
+func (b Bytes) MarshalJSON() ([]byte, error) {
+	return json.Marshal(base64.RawURLEncoding.EncodeToString(b))
+}
+
+// UnmarshalJSON decodes base64url, with or without padding
+func (b *Bytes) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+	if err != nil {
+		return err
+	}
+
+	*b = decoded
+	return nil
+}
+
+// Config is who we are to the authenticators
+type Config struct {
+	// RPID is the domain credentials are bound to, like thelsblog.com. It can't be changed later
+	// without every user registering their passkeys again
+	RPID   string
+	RPName string
+	// Origins are the origins (scheme://host[:port]) of the front end that may start a ceremony
+	Origins []string
+	// Timeout is how long the browser waits for the user
+	Timeout time.Duration
+	// Secret is the key of FakeCredentialID, it is random when it is not set. With a random secret the made up
+	// credentials of a user change when the server restarts
+	Secret []byte
+}
+
+// WebAuthn runs the ceremonies for one relying party
+type WebAuthn struct {
+	config Config
+}
+
+// New returns a WebAuthn for config
+func New(config Config) (*WebAuthn, error) {
+	if config.RPID == "" {
+		return nil, errors.New("webauthn: a relying party id is needed")
+	}
+	if len(config.Origins) == 0 {
+		return nil, errors.New("webauthn: at least one origin is needed")
+	}
+	if config.RPName == "" {
+		config.RPName = config.RPID
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Minute
+	}
+	if len(config.Secret) == 0 {
+		config.Secret = make([]byte, 32)
+		_, err := rand.Read(config.Secret)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &WebAuthn{config: config}, nil
+}
+
+// FakeCredentialID makes up a credential id for name, the same one every time. Login options for somebody
+// without passkeys, or who doesn't exist, list it so they look like the options of anybody else
+func (w *WebAuthn) FakeCredentialID(name string) []byte {
+	mac := hmac.New(sha256.New, w.config.Secret)
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(name))))
+	return mac.Sum(nil)
+}
+
+// User is the account a credential is registered for
+type User struct {
+	// ID is an opaque handle for the user, it must not contain personal information like the email address
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// CredentialDescriptor names a credential in options
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   Bytes  `json:"id"`
+}
+
+// descriptors turns credential ids into descriptors
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	list := []CredentialDescriptor{}
+	for _, id := range ids {
+		list = append(list, CredentialDescriptor{Type: "public-key", ID: id})
+	}
+	return list
+}
+
+// CreationOptions is the publicKey argument of navigator.credentials.create()
+type CreationOptions struct {
+	Challenge Bytes `json:"challenge"`
+	RP        struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	} `json:"rp"`
+	User struct {
+		ID          Bytes  `json:"id"`
+		Name        string `json:"name"`
+		DisplayName string `json:"displayName"`
+	} `json:"user"`
+	PubKeyCredParams []struct {
+		Type string `json:"type"`
+		Alg  int    `json:"alg"`
+	} `json:"pubKeyCredParams"`
+	Timeout                int                    `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection struct {
+		ResidentKey      string `json:"residentKey"`
+		UserVerification string `json:"userVerification"`
+	} `json:"authenticatorSelection"`
+	Attestation string `json:"attestation"`
+}
+
+// RequestOptions is the publicKey argument of navigator.credentials.get()
+type RequestOptions struct {
+	Challenge        Bytes                  `json:"challenge"`
+	Timeout          int                    `json:"timeout"`
+	RPID             string                 `json:"rpId"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// BeginRegistration returns the options to register a new credential for user. exclude are the credentials the user
+// already has, so the same authenticator is not registered twice. The caller keeps challenge to check the response
+func (w *WebAuthn) BeginRegistration(user User, challenge []byte, exclude [][]byte) CreationOptions {
+	var options CreationOptions
+	options.Challenge = challenge
+	options.RP.ID = w.config.RPID
+	options.RP.Name = w.config.RPName
+	options.User.ID = user.ID
+	options.User.Name = user.Name
+	options.User.DisplayName = user.DisplayName
+	for _, alg := range SupportedAlgorithms {
+		options.PubKeyCredParams = append(options.PubKeyCredParams, struct {
+			Type string `json:"type"`
+			Alg  int    `json:"alg"`
+		}{"public-key", alg})
+	}
+	options.Timeout = int(w.config.Timeout / time.Millisecond)
+	options.ExcludeCredentials = descriptors(exclude)
+	options.AuthenticatorSelection.ResidentKey = "preferred"
+	// a passkey replaces the password, so the authenticator has to check it is really the user (pin, fingerprint)
+	options.AuthenticatorSelection.UserVerification = "required"
+	options.Attestation = "none"
+
+	return options
+}
+
+// BeginLogin returns the options to log in with one of the credentials in allow
+func (w *WebAuthn) BeginLogin(challenge []byte, allow [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        challenge,
+		Timeout:          int(w.config.Timeout / time.Millisecond),
+		RPID:             w.config.RPID,
+		AllowCredentials: descriptors(allow),
+		UserVerification: "required",
+	}
+}
+
+// RegistrationResponse is the PublicKeyCredential from navigator.credentials.create(), as JSON
+type RegistrationResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AttestationObject Bytes `json:"attestationObject"`
+	} `json:"response"`
+}
+
+// AssertionResponse is the PublicKeyCredential from navigator.credentials.get(), as JSON
+type AssertionResponse struct {
+	ID       string `json:"id"`
+	RawID    Bytes  `json:"rawId"`
+	Type     string `json:"type"`
+	Response struct {
+		ClientDataJSON    Bytes `json:"clientDataJSON"`
+		AuthenticatorData Bytes `json:"authenticatorData"`
+		Signature         Bytes `json:"signature"`
+		UserHandle        Bytes `json:"userHandle,omitempty"`
+	} `json:"response"`
+}
+
+// Credential is what we keep of a registered credential
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE key, as the authenticator sent it
+	PublicKey []byte
+	SignCount uint32
+}
+
+// clientData is the json the browser makes and the authenticator signs a hash of
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// Challenge returns the challenge in clientDataJSON, so the caller can look up the ceremony a response belongs to.
+// Nothing is verified yet, that happens in FinishRegistration and FinishLogin
+func Challenge(clientDataJSON []byte) ([]byte, error) {
+	var c clientData
+	if err := json.Unmarshal(clientDataJSON, &c); err != nil {
+		return nil, fmt.Errorf("webauthn: invalid client data: %s", err.Error())
+	}
+
+	challenge, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(c.Challenge, "="))
+	if err != nil || len(challenge) == 0 {
+		return nil, errors.New("webauthn: invalid challenge in client data")
+	}
+
+	return challenge, nil
+}
+
+// checkClientData verifies the client data of a ceremony of type ceremony ("webauthn.create" or "webauthn.get")
+func (w *WebAuthn) checkClientData(clientDataJSON []byte, ceremony string, challenge []byte) error {
+	var c clientData
+	if err := json.Unmarshal(clientDataJSON, &c); err != nil {
+		return fmt.Errorf("webauthn: invalid client data: %s", err.Error())
+	}
+
+	if c.Type != ceremony {
+		return fmt.Errorf("webauthn: expected a %s response but got %q", ceremony, c.Type)
+	}
+
+	got, err := Challenge(clientDataJSON)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, challenge) {
+		return errors.New("webauthn: challenge does not match")
+	}
+
+	originOK := false
+	for _, origin := range w.config.Origins {
+		if c.Origin == origin {
+			originOK = true
+		}
+	}
+	if !originOK || c.CrossOrigin {
+		return fmt.Errorf("webauthn: origin %q is not allowed", c.Origin)
+	}
+
+	return nil
+}
+
+// checkAuthenticatorData verifies the parts of authenticator data that are the same for both ceremonies
+func (w *WebAuthn) checkAuthenticatorData(data *authenticatorData) error {
+	rpIDHash := sha256.Sum256([]byte(w.config.RPID))
+	if !bytes.Equal(data.rpIDHash, rpIDHash[:]) {
+		return errors.New("webauthn: credential is for another relying party")
+	}
+
+	if data.flags&flagUserPresent == 0 {
+		return errors.New("webauthn: user was not present")
+	}
+	if data.flags&flagUserVerified == 0 {
+		return errors.New("webauthn: user was not verified")
+	}
+
+	return nil
+}
+
+// FinishRegistration verifies the response to BeginRegistration and returns the new credential
+func (w *WebAuthn) FinishRegistration(response RegistrationResponse, challenge []byte) (*Credential, error) {
+	if response.Type != "public-key" {
+		return nil, errors.New("webauthn: not a public key credential")
+	}
+
+	err := w.checkClientData(response.Response.ClientDataJSON, "webauthn.create", challenge)
+	if err != nil {
+		return nil, err
+	}
+
+	v, n, err := decodeCBOR(response.Response.AttestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: invalid attestation object: %s", err.Error())
+	}
+
+	attestation, ok := v.(map[interface{}]interface{})
+	if !ok || n != len(response.Response.AttestationObject) {
+		return nil, errors.New("webauthn: invalid attestation object")
+	}
+
+	rawAuthData, ok := attestation["authData"].([]byte)
+	if !ok {
+		return nil, errors.New("webauthn: attestation object has no authenticator data")
+	}
+
+	data, err := parseAuthenticatorData(rawAuthData)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: %s", err.Error())
+	}
+
+	err = w.checkAuthenticatorData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if data.credentialID == nil {
+		return nil, errors.New("webauthn: no credential in the response")
+	}
+
+	if !bytes.Equal(data.credentialID, response.RawID) {
+		return nil, errors.New("webauthn: credential id does not match")
+	}
+
+	// make sure we can use the key before we store it
+	_, err = parsePublicKey(data.publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("webauthn: %s", err.Error())
+	}
+
+	return &Credential{
+		ID:        append([]byte(nil), data.credentialID...),
+		PublicKey: append([]byte(nil), data.publicKey...),
+		SignCount: data.signCount,
+	}, nil
+}
+
+// FinishLogin verifies the response to BeginLogin, signed with credential, and returns the new signature counter
+// to store with the credential
+func (w *WebAuthn) FinishLogin(response AssertionResponse, challenge []byte, credential Credential) (uint32, error) {
+	if response.Type != "public-key" {
+		return 0, errors.New("webauthn: not a public key credential")
+	}
+
+	if !bytes.Equal(response.RawID, credential.ID) {
+		return 0, errors.New("webauthn: credential id does not match")
+	}
+
+	err := w.checkClientData(response.Response.ClientDataJSON, "webauthn.get", challenge)
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := parseAuthenticatorData(response.Response.AuthenticatorData)
+	if err != nil {
+		return 0, fmt.Errorf("webauthn: %s", err.Error())
+	}
+
+	err = w.checkAuthenticatorData(data)
+	if err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, fmt.Errorf("webauthn: %s", err.Error())
+	}
+
+	// the signature is over the authenticator data followed by the hash of the client data
+	clientDataHash := sha256.Sum256(response.Response.ClientDataJSON)
+	message := append(append([]byte(nil), response.Response.AuthenticatorData...), clientDataHash[:]...)
+
+	if !key.verify(message, response.Response.Signature) {
+		return 0, errors.New("webauthn: invalid signature")
+	}
+
+	// authenticators that don't count always send 0, the others have to go up every time
+	if (data.signCount != 0 || credential.SignCount != 0) && data.signCount <= credential.SignCount {
+		return 0, ErrClonedAuthenticator
+	}
+
+	return data.signCount, nil
+}
@@ -0,0 +1,185 @@
+package webauthn
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+const (
+	testRPID   = "thelsblog.com"
+	testOrigin = "https://thelsblog.com"
+)
+
+func testWebAuthn(t *testing.T) *WebAuthn {
+	w, err := New(Config{RPID: testRPID, RPName: "ThelsBlog", Origins: []string{testOrigin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestWebAuthn_Ceremonies(t *testing.T) {
+	w := testWebAuthn(t)
+
+	for _, alg := range []int{AlgES256, AlgEdDSA} {
+		a := newSoftAuthenticator(t, testRPID, testOrigin, alg)
+
+		challenge := []byte("registration challenge")
+		options := w.BeginRegistration(User{ID: []byte{1}, Name: "editor@example.com", DisplayName: "Editor"}, challenge, nil)
+		if !bytes.Equal(options.Challenge, challenge) || options.RP.ID != testRPID {
+			t.Fatalf("%d: got the wrong creation options: %+v", alg, options)
+		}
+
+		// the response goes through json like it does coming from the browser
+		var response RegistrationResponse
+		roundTrip(t, a.create(challenge), &response)
+
+		credential, err := w.FinishRegistration(response, challenge)
+		if err != nil {
+			t.Fatalf("%d: expected the registration to verify but got %v", alg, err)
+		}
+		if !bytes.Equal(credential.ID, a.id) {
+			t.Errorf("%d: got the wrong credential id", alg)
+		}
+
+		challenge = []byte("login challenge")
+		var assertion AssertionResponse
+		roundTrip(t, a.get(t, challenge), &assertion)
+
+		signCount, err := w.FinishLogin(assertion, challenge, *credential)
+		if err != nil {
+			t.Fatalf("%d: expected the login to verify but got %v", alg, err)
+		}
+		if signCount != 1 {
+			t.Errorf("%d: expected sign count 1 but got %d", alg, signCount)
+		}
+
+		// the same assertion again looks like a cloned authenticator
+		credential.SignCount = signCount
+		_, err = w.FinishLogin(assertion, challenge, *credential)
+		if !errors.Is(err, ErrClonedAuthenticator) {
+			t.Errorf("%d: expected ErrClonedAuthenticator but got %v", alg, err)
+		}
+	}
+}
+
+func TestWebAuthn_FinishRegistration_invalid(t *testing.T) {
+	w := testWebAuthn(t)
+	challenge := []byte("challenge")
+
+	var theTests = []struct {
+		name   string
+		change func(a *softAuthenticator)
+	}{
+		{"other origin", func(a *softAuthenticator) { a.origin = "https://evil.example.com" }},
+		{"other relying party", func(a *softAuthenticator) { a.rpID = "evil.example.com" }},
+		{"user not verified", func(a *softAuthenticator) { a.flags = flagUserPresent }},
+	}
+
+	for _, e := range theTests {
+		a := newSoftAuthenticator(t, testRPID, testOrigin, AlgES256)
+		e.change(a)
+
+		_, err := w.FinishRegistration(a.create(challenge), challenge)
+		if err == nil {
+			t.Errorf("%s: expected the registration to fail", e.name)
+		}
+	}
+
+	a := newSoftAuthenticator(t, testRPID, testOrigin, AlgES256)
+	_, err := w.FinishRegistration(a.create(challenge), []byte("another challenge"))
+	if err == nil {
+		t.Error("expected a registration for another challenge to fail")
+	}
+
+	response := a.create(challenge)
+	response.Response.AttestationObject = response.Response.AttestationObject[:40]
+	_, err = w.FinishRegistration(response, challenge)
+	if err == nil {
+		t.Error("expected a truncated attestation object to fail")
+	}
+}
+
+func TestWebAuthn_FinishLogin_invalid(t *testing.T) {
+	w := testWebAuthn(t)
+	a := newSoftAuthenticator(t, testRPID, testOrigin, AlgES256)
+
+	credential, err := w.FinishRegistration(a.create([]byte("register")), []byte("register"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge := []byte("login")
+
+	// signed by another key
+	other := newSoftAuthenticator(t, testRPID, testOrigin, AlgES256)
+	other.id = a.id
+	_, err = w.FinishLogin(other.get(t, challenge), challenge, *credential)
+	if err == nil {
+		t.Error("expected a signature of another key to fail")
+	}
+
+	// a registration response is no login
+	response := a.get(t, challenge)
+	response.Response.ClientDataJSON = a.clientData("webauthn.create", challenge)
+	_, err = w.FinishLogin(response, challenge, *credential)
+	if err == nil {
+		t.Error("expected a response of the wrong type to fail")
+	}
+
+	// changing the authenticator data breaks the signature
+	response = a.get(t, challenge)
+	response.Response.AuthenticatorData[36]++
+	_, err = w.FinishLogin(response, challenge, *credential)
+	if err == nil {
+		t.Error("expected tampered authenticator data to fail")
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	a := newSoftAuthenticator(t, testRPID, testOrigin, AlgES256)
+
+	challenge, err := Challenge(a.clientData("webauthn.get", []byte("abc")))
+	if err != nil || string(challenge) != "abc" {
+		t.Errorf("expected challenge abc but got %q, %v", challenge, err)
+	}
+
+	_, err = Challenge([]byte(`{"challenge": ""}`))
+	if err == nil {
+		t.Error("expected an error for an empty challenge")
+	}
+}
+
+func Test_decodeCBOR(t *testing.T) {
+	var theTests = []struct {
+		name  string
+		input []byte
+		ok    bool
+	}{
+		{"map", encodeCBOR(map[interface{}]interface{}{"a": 1, -1: []byte{1, 2}}), true},
+		{"truncated bytes", []byte{0x45, 1, 2}, false},
+		{"huge array", []byte{0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff}, false},
+		{"indefinite length", []byte{0x5f}, false},
+		{"float", []byte{0xf9, 0x3c, 0x00}, false},
+	}
+
+	for _, e := range theTests {
+		_, _, err := decodeCBOR(e.input)
+		if (err == nil) != e.ok {
+			t.Errorf("%s: expected ok %t but got %v", e.name, e.ok, err)
+		}
+	}
+}
+
+// roundTrip sends v through json into out
+func roundTrip(t *testing.T, v, out interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, out); err != nil {
+		t.Fatal(err)
+	}
+}